@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -10,11 +13,13 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/vikramsk/rediproxy/pkg/api"
 	"github.com/vikramsk/rediproxy/pkg/cache"
+	"github.com/vikramsk/rediproxy/pkg/metrics"
 	"github.com/vikramsk/rediproxy/pkg/service"
 )
 
@@ -23,6 +28,21 @@ var (
 	defaultRedisURL = "localhost:6379"
 	defaultTTL      = time.Hour * 1
 	defaultCapacity = 1000000
+	defaultItemSize = "1MB"
+	defaultShards   = 1
+
+	defaultShutdownTimeout = time.Second * 30
+
+	defaultBreakerFailures    = 5
+	defaultBreakerWindow      = time.Second * 10
+	defaultBreakerOpenTimeout = time.Second * 30
+
+	defaultClusterRefresh  = time.Second * 30
+	defaultRingHealthCheck = time.Millisecond * 500
+
+	// envRedisPassword is the env var for the redis password,
+	// which keeps it out of the command line of the process.
+	envRedisPassword = "REDIPROXY_REDIS_PASSWORD"
 )
 
 func main() {
@@ -35,33 +55,207 @@ func main() {
 func run(args []string) error {
 	flagset := flag.NewFlagSet("rediproxy", flag.ExitOnError)
 	var (
-		port     = flagset.String("port", defaultPort, "proxy service port")
-		redisURL = flagset.String("redis-url", defaultRedisURL, "backing redis service address")
-		ttl      = flagset.Duration("ttl", defaultTTL, "time to live for cache entries")
-		capacity = flagset.Int("capacity", defaultCapacity, "keys limit for the cache")
+		port            = flagset.String("port", defaultPort, "proxy service port")
+		adminAddr       = flagset.String("admin-addr", "", "address for the endpoints which remove keys from the cache, e.g. 127.0.0.1:8081. disabled when empty")
+		redisURL        = flagset.String("redis-url", defaultRedisURL, "backing redis service address, as host:port or a redis:// or rediss:// url")
+		ttl             = flagset.Duration("ttl", defaultTTL, "time to live for cache entries")
+		capacity        = flagset.Int("capacity", defaultCapacity, "keys limit for the cache")
+		maxMemory       = flagset.String("max-memory", "", "bytes limit for the cache entries, e.g. 512MB. overrides the keys limit when set")
+		maxItemSize     = flagset.String("max-item-size", defaultItemSize, "bytes limit for a single cache entry when max-memory is set")
+		evictionPolicy  = flagset.String("eviction-policy", string(cache.PolicyLRU), "eviction policy for the cache: lru, lfu, arc or tinylfu. only lru removes expired keys in the background, the others on lookup")
+		shards          = flagset.Int("shards", defaultShards, "number of independent segments for the cache, each with its own lock")
+		shutdownTimeout = flagset.Duration("shutdown-timeout", defaultShutdownTimeout, "time to wait for in-flight requests to drain on shutdown")
+		keyTTL          = flagset.String("key-ttl", "", "comma separated time to live per key prefix, e.g. session:=5m,config:=24h")
+		staleWindow     = flagset.Duration("stale-while-revalidate", 0, "time after the ttl during which keys are served while they're refreshed in the background")
+		negativeTTL     = flagset.Duration("negative-ttl", 0, "time to live for keys missing in the backing redis service. disabled when 0")
+		gracePeriod     = flagset.Duration("stale-if-error", 0, "time after the ttl during which keys are served if the backing redis service fails")
+		backendTimeout  = flagset.Duration("backend-timeout", 0, "max. time for a call to the backing redis service, after which the request fails with 504. also caps the redis read and write timeouts. disabled when 0")
+
+		promotionWindow = flagset.Float64("promotion-window", cache.DefaultPromotionWindow, "min. time between moves of a key to the front of the lru, as a fraction of the ttl")
+		sampleSize      = flagset.Int("sample-size", cache.DefaultSampleSize, "number of keys checked for expiry by each run of the lru cleanup")
+		sampleInterval  = flagset.Duration("sample-interval", cache.DefaultSampleInterval, "time between runs of the lru cleanup")
+		sampleBudget    = flagset.Duration("sample-budget", cache.DefaultSampleBudget, "max. time for a run of the lru cleanup, which samples again while more than the repeat threshold of the keys have expired")
+		repeatThreshold = flagset.Float64("repeat-threshold", cache.DefaultRepeatThreshold, "fraction of expired keys in a sample above which the lru cleanup samples again right away")
+
+		breakerFailures    = flagset.Int("breaker-failures", defaultBreakerFailures, "consecutive failed redis calls which open the circuit breaker. disabled when 0")
+		breakerErrorRate   = flagset.Float64("breaker-error-rate", 0, "fraction of failed redis calls within the breaker window which opens the circuit breaker. disabled when 0")
+		breakerWindow      = flagset.Duration("breaker-window", defaultBreakerWindow, "window for the error rate of the circuit breaker")
+		breakerOpenTimeout = flagset.Duration("breaker-open-timeout", defaultBreakerOpenTimeout, "time the circuit breaker stays open before it probes redis for recovery")
+
+		redisUsername      = flagset.String("redis-username", "", "acl user for the redis connections. requires redis 6")
+		redisPassword      = flagset.String("redis-password", "", "password for the redis connections. defaults to $"+envRedisPassword)
+		redisDB            = flagset.Int("redis-db", 0, "index of the redis database")
+		redisTLS           = flagset.Bool("redis-tls", false, "enable tls for the redis connections. implied by a rediss:// url")
+		redisTLSCA         = flagset.String("redis-tls-ca", "", "pem file of the cas used to verify redis. defaults to the cas of the host")
+		redisTLSCert       = flagset.String("redis-tls-cert", "", "pem file of the client certificate for redis")
+		redisTLSKey        = flagset.String("redis-tls-key", "", "pem file of the client key for redis")
+		redisTLSServerName = flagset.String("redis-tls-server-name", "", "name used to verify redis. defaults to the host in the redis url")
+		redisPoolSize      = flagset.Int("redis-pool-size", 0, "max. number of redis connections. defaults to 10 per cpu")
+		redisMinIdleConns  = flagset.Int("redis-min-idle-conns", 0, "number of idle redis connections kept open")
+		redisDialTimeout   = flagset.Duration("redis-dial-timeout", 0, "timeout for new redis connections. defaults to 5s")
+		redisReadTimeout   = flagset.Duration("redis-read-timeout", 0, "timeout for redis reads. defaults to 3s")
+		redisWriteTimeout  = flagset.Duration("redis-write-timeout", 0, "timeout for redis writes. defaults to the read timeout")
+
+		sentinelMaster   = flagset.String("redis-sentinel-master", "", "name of the master monitored by redis sentinel. the redis url is only used for the credentials when set")
+		sentinelAddrs    = flagset.String("redis-sentinel-addrs", "", "comma separated addresses of the redis sentinels, e.g. sentinel1:26379,sentinel2:26379")
+		clusterNodes     = flagset.String("redis-cluster-nodes", "", "comma separated addresses of the seed nodes of a redis cluster, e.g. node1:6379,node2:6379. the redis url is only used for the credentials when set")
+		clusterRefresh   = flagset.Duration("redis-cluster-refresh", defaultClusterRefresh, "time between reloads of the slots of the redis cluster. reloaded on MOVED redirects and every minute when 0")
+		ringShards       = flagset.String("redis-ring-shards", "", "comma separated shards of a consistent hash ring of independent redis instances, as name=host:port or host:port. the redis url is only used for the credentials when set")
+		ringHealthCheck  = flagset.Duration("redis-ring-health-check", defaultRingHealthCheck, "time between the pings of each ring shard. a shard is removed from the ring after 3 failed pings")
+		tracking         = flagset.Bool("redis-tracking", false, "drop the keys modified in redis from the cache, using the client side caching of redis 6. only for a single redis instance")
+		trackingPrefixes = flagset.String("redis-tracking-prefixes", "", "comma separated key prefixes for which redis reports every modified key, instead of only the keys read by rediproxy")
+		readFromReplicas = flagset.Bool("redis-read-replicas", false, "send lookups to the replicas of the sentinel master or the cluster")
 	)
 
 	if err := flagset.Parse(args); err != nil {
 		return err
 	}
 
+	// the env var isn't the default of the flag,
+	// which would print it in the usage.
+	if *redisPassword == "" {
+		*redisPassword = os.Getenv(envRedisPassword)
+	}
+
 	_, err := strconv.Atoi(*port)
 	if err != nil {
 		return errors.New("rediproxy: service port parsing error")
 	}
 
-	rc, err := service.NewRedisClient(*redisURL)
+	redisOpts := service.RedisOptions{
+		Addr:          *redisURL,
+		Username:      *redisUsername,
+		Password:      *redisPassword,
+		DB:            *redisDB,
+		TLS:           *redisTLS,
+		TLSCAFile:     *redisTLSCA,
+		TLSCertFile:   *redisTLSCert,
+		TLSKeyFile:    *redisTLSKey,
+		TLSServerName: *redisTLSServerName,
+		PoolSize:      *redisPoolSize,
+		MinIdleConns:  *redisMinIdleConns,
+		DialTimeout:   *redisDialTimeout,
+		ReadTimeout:   *redisReadTimeout,
+		WriteTimeout:  *redisWriteTimeout,
+		CallTimeout:   *backendTimeout,
+	}
+	rc, err := newBackend(redisOpts, backendOptions{
+		sentinelMaster:   *sentinelMaster,
+		sentinelAddrs:    *sentinelAddrs,
+		clusterNodes:     *clusterNodes,
+		clusterRefresh:   *clusterRefresh,
+		ringShards:       *ringShards,
+		ringHealthCheck:  *ringHealthCheck,
+		tracking:         *tracking,
+		trackingPrefixes: *trackingPrefixes,
+		readFromReplicas: *readFromReplicas,
+	})
 	if err != nil {
 		return err
 	}
 
-	lc := cache.NewLRUCache(*capacity, *ttl)
+	// the resources are released in the reverse
+	// order, once the requests are drained or the
+	// service fails to start.
+	if closer, ok := rc.(io.Closer); ok {
+		defer logClose("redis client", closer)
+	}
+
+	keyTTLs, err := parseKeyTTLs(*keyTTL)
+	if err != nil {
+		return err
+	}
+
+	// the capacity is ignored when
+	// the cache is bounded by bytes.
+	if *shards < 1 || (*maxMemory == "" && *shards > *capacity) {
+		return errors.New("rediproxy: shards must be between 1 and the capacity")
+	}
+
+	// the limits are split evenly across the shards.
+	policy := cache.Policy(*evictionPolicy)
+	if !policy.Valid() {
+		return cache.ErrUnknownPolicy
+	}
+	lruOpts := []cache.Option{
+		cache.WithTTL(*ttl),
+		cache.WithPromotionWindow(*promotionWindow),
+		cache.WithSampleSize(*sampleSize),
+		cache.WithSampleInterval(*sampleInterval),
+		cache.WithSampleBudget(*sampleBudget),
+		cache.WithRepeatThreshold(*repeatThreshold),
+	}
+	newShard := func() cache.Cacher {
+		if policy == cache.PolicyLRU {
+			return cache.NewLRU(append(lruOpts, cache.WithCapacity(*capacity / *shards))...)
+		}
+		c, _ := cache.New(policy, *capacity / *shards, *ttl)
+		return c
+	}
+	if *maxMemory != "" {
+		if policy != cache.PolicyLRU {
+			return errors.New("rediproxy: max-memory is only supported by the lru eviction policy")
+		}
+		maxBytes, err := parseBytes(*maxMemory)
+		if err != nil {
+			return err
+		}
+		maxItemBytes, err := parseBytes(*maxItemSize)
+		if err != nil {
+			return err
+		}
+		newShard = func() cache.Cacher {
+			return cache.NewLRU(append(lruOpts, cache.WithMaxBytes(maxBytes/int64(*shards), maxItemBytes))...)
+		}
+	}
+
+	lc := newShard()
+	if *shards > 1 {
+		lc = cache.NewShardedCache(*shards, newShard)
+	}
+	defer logClose("cache", lc)
 
-	pc := service.NewCacheProxy(rc, lc)
+	proxyOpts := []service.ProxyOption{
+		service.WithTTL(*ttl),
+		service.WithKeyTTL(service.PrefixTTL(keyTTLs)),
+		service.WithStaleWhileRevalidate(*staleWindow),
+		service.WithStaleIfError(*gracePeriod),
+		service.WithBackendTimeout(*backendTimeout),
+	}
+
+	// the negative cache holds no values, so
+	// it's only limited by the number of keys.
+	var nc cache.Cacher
+	if *negativeTTL > 0 {
+		nc = cache.NewLRUCache(*capacity, *negativeTTL)
+		proxyOpts = append(proxyOpts, service.WithNegativeCache(nc))
+		defer logClose("negative cache", nc)
+	}
+
+	// guard the calls to redis with a circuit
+	// breaker, if it's enabled.
+	backend := rc
+	if *breakerFailures > 0 || *breakerErrorRate > 0 {
+		backend = service.NewCircuitBreaker(rc,
+			service.WithFailureThreshold(*breakerFailures),
+			service.WithErrorRate(*breakerErrorRate, *breakerWindow),
+			service.WithOpenTimeout(*breakerOpenTimeout),
+		)
+	}
+
+	// the proxy waits for the background
+	// refreshes of the keys when it's closed.
+	pc := service.NewCacheProxy(backend, lc, proxyOpts...)
+	if closer, ok := pc.(io.Closer); ok {
+		defer logClose("cache proxy", closer)
+	}
 
 	ph := api.NewProxyHandler(pc)
 
 	apiListener, err := net.Listen("tcp", ":"+*port)
+	if err != nil {
+		return err
+	}
 	mux := http.NewServeMux()
 
 	// Register pprof handlers
@@ -71,22 +265,238 @@ func run(args []string) error {
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
-	mux.Handle("/", ph)
+	// Register stats handler
+	if sr, ok := pc.(service.StatsReporter); ok {
+		mux.Handle("/stats", api.NewStatsHandler(func() interface{} {
+			return sr.Stats()
+		}))
+	}
 
-	go interrupt(apiListener)
+	// Register metrics handler
+	if sr, ok := lc.(cache.StatsReporter); ok {
+		metrics.MustRegister(cache.NewCollectors(sr)...)
+	}
+	if br, ok := backend.(service.BreakerStatsReporter); ok {
+		metrics.MustRegister(service.NewBreakerCollectors(br)...)
+	}
+	mux.Handle("/metrics", metrics.Handler())
+
+	mux.Handle("/", api.Instrument(ph))
+
+	srv := &http.Server{Handler: mux}
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- srv.Serve(apiListener)
+	}()
+
+	// the endpoints which remove keys are only
+	// served on the admin address, if it's set.
+	var adminSrv *http.Server
+	if *adminAddr != "" {
+		adminListener, err := net.Listen("tcp", *adminAddr)
+		if err != nil {
+			srv.Close()
+			return err
+		}
+		adminSrv = &http.Server{Handler: api.Instrument(api.NewAdminHandler(pc))}
+		go func() {
+			serveErr <- adminSrv.Serve(adminListener)
+		}()
+		log.Printf("launching admin endpoints on: %s", *adminAddr)
+	}
 
 	log.Printf("launching cache proxy on port: %s", *port)
-	log.Fatalf("http server error: %s", http.Serve(apiListener, mux))
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("rediproxy: http server error: %v", err)
+	case sig := <-interrupt():
+		log.Printf("received signal: %s, shutting down", sig)
+	}
+
+	// drain the in-flight requests before
+	// releasing the cache and the client. the
+	// requests left after the timeout are cut off.
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	if adminSrv != nil {
+		if aerr := adminSrv.Shutdown(ctx); err == nil {
+			err = aerr
+		}
+	}
+	if err != nil {
+		srv.Close()
+		if adminSrv != nil {
+			adminSrv.Close()
+		}
+		return fmt.Errorf("rediproxy: could not drain in-flight requests: %v", err)
+	}
 
+	log.Printf("cache proxy shut down")
 	return nil
 }
 
-func interrupt(apiListener net.Listener) {
+// logClose closes the resource on shutdown,
+// and logs the error if it fails.
+func logClose(name string, c io.Closer) {
+	if err := c.Close(); err != nil {
+		log.Printf("rediproxy: could not close the %s. err: %v", name, err)
+	}
+}
+
+// interrupt returns a channel which receives
+// the SIGINT and SIGTERM signals.
+func interrupt() <-chan os.Signal {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	select {
-	case sig := <-c:
-		log.Printf("received signal: %s", sig)
-		apiListener.Close()
+	return c
+}
+
+// backendOptions selects the topology
+// of the backing redis service.
+type backendOptions struct {
+	sentinelMaster string
+	sentinelAddrs  string
+
+	clusterNodes   string
+	clusterRefresh time.Duration
+
+	ringShards      string
+	ringHealthCheck time.Duration
+
+	tracking         bool
+	trackingPrefixes string
+
+	readFromReplicas bool
+}
+
+// newBackend initializes the client for the backing redis
+// service. It uses the cluster nodes, the sentinel master
+// or the ring shards, if one of them is set. Otherwise, it
+// tracks the keys read from the single instance, if enabled.
+func newBackend(o service.RedisOptions, bo backendOptions) (cache.Getter, error) {
+	tracking := bo.tracking || bo.trackingPrefixes != ""
+	set := 0
+	for _, v := range []string{bo.clusterNodes, bo.sentinelMaster, bo.ringShards} {
+		if v != "" {
+			set++
+		}
+	}
+
+	switch {
+	case set > 1:
+		return nil, errors.New("rediproxy: redis cluster nodes, sentinel master and ring shards are mutually exclusive")
+	case set > 0 && tracking:
+		return nil, errors.New("rediproxy: redis tracking is only supported for a single redis instance")
+	case tracking:
+		var prefixes []string
+		if bo.trackingPrefixes != "" {
+			prefixes = strings.Split(bo.trackingPrefixes, ",")
+		}
+		return service.NewTrackingClient(service.TrackingOptions{
+			Prefixes:     prefixes,
+			RedisOptions: o,
+		})
+	case bo.ringShards != "":
+		shards, err := parseRingShards(bo.ringShards)
+		if err != nil {
+			return nil, err
+		}
+		return service.NewRingClient(service.RingOptions{
+			Shards:              shards,
+			HealthCheckInterval: bo.ringHealthCheck,
+			RedisOptions:        o,
+		})
+	case bo.clusterNodes != "":
+		return service.NewClusterClient(service.ClusterOptions{
+			Addrs:            strings.Split(bo.clusterNodes, ","),
+			ReadFromReplicas: bo.readFromReplicas,
+			RefreshInterval:  bo.clusterRefresh,
+			RedisOptions:     o,
+		})
+	case bo.sentinelMaster != "":
+		if bo.sentinelAddrs == "" {
+			return nil, errors.New("rediproxy: redis sentinel addresses are required with the sentinel master")
+		}
+		return service.NewSentinelClient(service.SentinelOptions{
+			MasterName:       bo.sentinelMaster,
+			SentinelAddrs:    strings.Split(bo.sentinelAddrs, ","),
+			ReadFromReplicas: bo.readFromReplicas,
+			RedisOptions:     o,
+		})
+	}
+	return service.NewRedisClientWithOptions(o)
+}
+
+// parseRingShards parses a comma separated list of
+// name=host:port shards into a map. Shards without
+// a name are named after their address.
+func parseRingShards(s string) (map[string]string, error) {
+	shards := make(map[string]string)
+	for _, shard := range strings.Split(s, ",") {
+		name, addr := shard, shard
+		if i := strings.Index(shard, "="); i >= 0 {
+			name, addr = shard[:i], shard[i+1:]
+		}
+		if name == "" || addr == "" {
+			return nil, errors.New("rediproxy: ring shards parsing error, expected name=host:port")
+		}
+		if _, ok := shards[name]; ok {
+			return nil, errors.New("rediproxy: ring shards parsing error, duplicate shard " + name)
+		}
+		shards[name] = addr
+	}
+	return shards, nil
+}
+
+// parseKeyTTLs parses a comma separated list of
+// prefix=duration pairs into a map.
+func parseKeyTTLs(s string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+	if s == "" {
+		return ttls, nil
+	}
+	for _, rule := range strings.Split(s, ",") {
+		i := strings.LastIndex(rule, "=")
+		if i < 0 {
+			return nil, errors.New("rediproxy: key ttl parsing error, expected prefix=duration")
+		}
+		d, err := time.ParseDuration(rule[i+1:])
+		if err != nil {
+			return nil, errors.New("rediproxy: key ttl parsing error, invalid duration for prefix " + rule[:i])
+		}
+		ttls[rule[:i]] = d
+	}
+	return ttls, nil
+}
+
+// byteUnits maps the supported size
+// suffixes to their multipliers.
+var byteUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseBytes parses a size like 512MB into bytes.
+// Sizes without a suffix are treated as bytes.
+func parseBytes(s string) (int64, error) {
+	size := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(size, u.suffix) {
+			size = strings.TrimSpace(strings.TrimSuffix(size, u.suffix))
+			multiplier = u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("rediproxy: size parsing error for " + s)
 	}
+	return n * multiplier, nil
 }
@@ -3,9 +3,9 @@
 go test -v ./pkg/api/...
 go test -v -bench=. ./pkg/cache/...
 
-# Uses the redis-url flag to run tests
-# against the redis service.
-go test -v ./pkg/service/... $1
+# Uses the redis-url and sentinel-addr flags
+# to run tests against the redis services.
+go test -v ./pkg/service/... $1 $3
 
 # e2e test scenario
 go test -v ./tests/... $1 $2
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/vikramsk/rediproxy/pkg/cache"
+)
+
+const apiPathFlush = "/admin/flush"
+
+// AdminHandler serves the endpoints which remove
+// keys from the proxy caching service. It's meant
+// to be served apart from the ProxyHandler, on an
+// address which isn't exposed to the clients.
+type AdminHandler struct {
+	// deleter and flusher are set if the
+	// proxy service supports removing keys.
+	deleter cache.Deleter
+	flusher cache.Flusher
+}
+
+// ensure that the handler implements
+// the http.Handler interface
+var _ = http.Handler(&AdminHandler{})
+
+// NewAdminHandler initializes a new AdminHandler.
+// It accepts the proxy service as a parameter. The
+// delete and flush endpoints are served if the proxy
+// service implements cache.Deleter and cache.Flusher.
+func NewAdminHandler(ps cache.Getter) *AdminHandler {
+	ah := &AdminHandler{}
+	ah.deleter, _ = ps.(cache.Deleter)
+	ah.flusher, _ = ps.(cache.Flusher)
+	return ah
+}
+
+// ServeHTTP implements the http handler for the admin endpoints.
+func (ah *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "DELETE" && r.URL.Path == apiPathCache && ah.deleter != nil:
+		ah.handleDeleteRequest(w, r)
+	case r.Method == "POST" && r.URL.Path == apiPathFlush && ah.flusher != nil:
+		ah.handleFlushRequest(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ah *AdminHandler) handleDeleteRequest(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get(paramKey)
+	if key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !ah.deleter.Delete(key) {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ah *AdminHandler) handleFlushRequest(w http.ResponseWriter, r *http.Request) {
+	ah.flusher.Flush()
+	w.WriteHeader(http.StatusNoContent)
+}
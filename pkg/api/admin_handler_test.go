@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vikramsk/rediproxy/pkg/internal/mocks"
+)
+
+func TestAdminHandler(t *testing.T) {
+	scenarios := []scenario{
+		{
+			name:           "delete without support for deletes should return not found",
+			method:         "DELETE",
+			reqURL:         "http://test/cache?key=test",
+			expectedStatus: http.StatusNotFound,
+			proxyService: &mocks.Getter{
+				GetFn: cacheHit,
+			},
+		},
+		{
+			name:           "delete with empty key should return bad request",
+			method:         "DELETE",
+			reqURL:         "http://test/cache?key=",
+			expectedStatus: http.StatusBadRequest,
+			proxyService:   newMockProxy(deleted),
+		},
+		{
+			name:           "delete of a missing key should return not found",
+			method:         "DELETE",
+			reqURL:         "http://test/cache?key=test",
+			expectedStatus: http.StatusNotFound,
+			proxyService:   newMockProxy(notDeleted),
+		},
+		{
+			name:           "delete of a cached key should return no content",
+			method:         "DELETE",
+			reqURL:         "http://test/cache?key=test",
+			expectedStatus: http.StatusNoContent,
+			proxyService:   newMockProxy(deleted),
+		},
+		{
+			name:           "get should return not found",
+			method:         "GET",
+			reqURL:         "http://test/cache?key=test",
+			expectedStatus: http.StatusNotFound,
+			proxyService:   newMockProxy(deleted),
+		},
+		{
+			name:           "flush with GET should return not found",
+			method:         "GET",
+			reqURL:         "http://test/admin/flush",
+			expectedStatus: http.StatusNotFound,
+			proxyService:   newMockProxy(deleted),
+		},
+		{
+			name:           "flush should return no content",
+			method:         "POST",
+			reqURL:         "http://test/admin/flush",
+			expectedStatus: http.StatusNoContent,
+			proxyService:   newMockProxy(deleted),
+		},
+	}
+
+	for _, s := range scenarios {
+		req := httptest.NewRequest(s.method, s.reqURL, nil)
+		w := httptest.NewRecorder()
+		NewAdminHandler(s.proxyService).ServeHTTP(w, req)
+
+		if resp := w.Result(); resp.StatusCode != s.expectedStatus {
+			t.Errorf("Admin Handler test failed for: %s, expected: %d, received: %d", s.name, s.expectedStatus, resp.StatusCode)
+		}
+	}
+}
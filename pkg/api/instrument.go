@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vikramsk/rediproxy/pkg/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec(
+		"rediproxy_http_requests_total",
+		"Number of HTTP requests by method and status code.",
+		"method", "code",
+	)
+	httpDuration = metrics.NewHistogramVec(
+		"rediproxy_http_request_duration_seconds",
+		"Latency of HTTP requests in seconds by method and status code.",
+		metrics.DefBuckets,
+		"method", "code",
+	)
+	httpStaleResponses = metrics.NewCounterVec(
+		"rediproxy_http_stale_responses_total",
+		"Number of responses served with a stale value because the backing store failed.",
+	)
+)
+
+func init() {
+	metrics.MustRegister(httpRequests, httpDuration, httpStaleResponses)
+}
+
+// statusRecorder records the status
+// code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Instrument wraps the handler to record the
+// count and the latency of the requests served
+// by it, partitioned by their status code.
+func Instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sr, r)
+
+		code := strconv.Itoa(sr.status)
+		httpRequests.WithLabelValues(r.Method, code).Inc()
+		httpDuration.WithLabelValues(r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	handler := Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	before := httpRequests.WithLabelValues("GET", "418").Value()
+
+	req := httptest.NewRequest("GET", "http://test/cache", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Result().StatusCode != http.StatusTeapot {
+		t.Fatalf("expected the status code of the handler to be written")
+	}
+	if httpRequests.WithLabelValues("GET", "418").Value() != before+1 {
+		t.Fatalf("expected the request to be counted by its status code")
+	}
+}
@@ -1,20 +1,30 @@
 package api
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/vikramsk/rediproxy/pkg/cache"
+	"github.com/vikramsk/rediproxy/pkg/service"
 )
 
 const (
 	apiPathCache = "/cache"
 	paramKey     = "key"
+
+	// statusClientClosedRequest is the non-standard status
+	// for requests canceled by the client before a response.
+	statusClientClosedRequest = 499
 )
 
 // ProxyHandler is a wrapper for the
 // proxy caching service.
 type ProxyHandler struct {
 	proxyService cache.Getter
+
+	// contextGetter is set if the proxy
+	// service accepts a request context.
+	contextGetter cache.ContextGetter
 }
 
 // ensure that the handler implements
@@ -22,11 +32,16 @@ type ProxyHandler struct {
 var _ = http.Handler(&ProxyHandler{})
 
 // NewProxyHandler initializes a new ProxyHandler.
-// It accepts the proxy service as a parameter.
+// It accepts the proxy service as a parameter. The
+// request context is passed on if it's a cache.ContextGetter.
+// The endpoints which remove keys are served by the
+// AdminHandler.
 func NewProxyHandler(ps cache.Getter) *ProxyHandler {
-	return &ProxyHandler{
+	ph := &ProxyHandler{
 		proxyService: ps,
 	}
+	ph.contextGetter, _ = ps.(cache.ContextGetter)
+	return ph
 }
 
 // ServeHTTP implements the http handler for the proxy.
@@ -46,14 +61,43 @@ func (ph *ProxyHandler) handleGetRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	val, err := ph.proxyService.Get(key)
-	if err == cache.ErrKeyNotFound {
+	val, err := ph.get(r.Context(), key)
+	if _, ok := err.(*cache.StaleError); ok {
+		// the value is served, but marked as stale.
+		// https://tools.ietf.org/html/rfc7234#section-5.5
+		w.Header().Add("Warning", `110 - "Response is Stale"`)
+		w.Header().Add("Warning", `111 - "Revalidation Failed"`)
+		httpStaleResponses.WithLabelValues().Inc()
+		err = nil
+	}
+
+	switch err {
+	case nil:
+	case cache.ErrKeyNotFound:
 		w.WriteHeader(http.StatusNoContent)
 		return
-	} else if err != nil {
+	case context.DeadlineExceeded:
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	case service.ErrCircuitOpen:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	case context.Canceled:
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	default:
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	w.Write([]byte(val))
 }
+
+// get looks up the key with the proxy service, passing
+// on the request context if the service accepts it.
+func (ph *ProxyHandler) get(ctx context.Context, key string) (string, error) {
+	if ph.contextGetter != nil {
+		return ph.contextGetter.GetContext(ctx, key)
+	}
+	return ph.proxyService.Get(key)
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -8,13 +9,39 @@ import (
 
 	"github.com/vikramsk/rediproxy/pkg/cache"
 	"github.com/vikramsk/rediproxy/pkg/internal/mocks"
+	"github.com/vikramsk/rediproxy/pkg/service"
 )
 
 type scenario struct {
 	name           string
+	method         string
 	reqURL         string
 	expectedStatus int
-	proxyService   *mocks.Getter
+	proxyService   cache.Getter
+}
+
+// mockProxy is a proxy service which
+// supports removing keys.
+type mockProxy struct {
+	*mocks.Getter
+	*mocks.Deleter
+	*mocks.Flusher
+}
+
+func newMockProxy(deleteFn func(string) bool) *mockProxy {
+	return &mockProxy{
+		Getter:  &mocks.Getter{GetFn: cacheHit},
+		Deleter: &mocks.Deleter{DeleteFn: deleteFn},
+		Flusher: &mocks.Flusher{FlushFn: func() {}},
+	}
+}
+
+func deleted(key string) bool {
+	return true
+}
+
+func notDeleted(key string) bool {
+	return false
 }
 
 func cacheHit(key string) (string, error) {
@@ -29,6 +56,22 @@ func internalError(key string) (string, error) {
 	return "", errors.New("internal error")
 }
 
+func staleHit(key string) (string, error) {
+	return key, &cache.StaleError{Err: errors.New("internal error")}
+}
+
+func backendTimeout(key string) (string, error) {
+	return "", context.DeadlineExceeded
+}
+
+func clientCanceled(key string) (string, error) {
+	return "", context.Canceled
+}
+
+func circuitOpen(key string) (string, error) {
+	return "", service.ErrCircuitOpen
+}
+
 func TestAPIHandler(t *testing.T) {
 	scenarios := []scenario{
 		{
@@ -59,6 +102,30 @@ func TestAPIHandler(t *testing.T) {
 				GetFn: internalError,
 			},
 		},
+		{
+			name:           "backend timeout should return gateway timeout",
+			reqURL:         "http://test/cache?key=test",
+			expectedStatus: http.StatusGatewayTimeout,
+			proxyService: &mocks.Getter{
+				GetFn: backendTimeout,
+			},
+		},
+		{
+			name:           "open circuit breaker should return service unavailable",
+			reqURL:         "http://test/cache?key=test",
+			expectedStatus: http.StatusServiceUnavailable,
+			proxyService: &mocks.Getter{
+				GetFn: circuitOpen,
+			},
+		},
+		{
+			name:           "canceled request should return client closed request",
+			reqURL:         "http://test/cache?key=test",
+			expectedStatus: statusClientClosedRequest,
+			proxyService: &mocks.Getter{
+				GetFn: clientCanceled,
+			},
+		},
 		{
 			name:           "valid output with no errors should return OK",
 			reqURL:         "http://test/cache?key=test",
@@ -67,10 +134,36 @@ func TestAPIHandler(t *testing.T) {
 				GetFn: cacheHit,
 			},
 		},
+		{
+			name:           "stale value should return OK",
+			reqURL:         "http://test/cache?key=test",
+			expectedStatus: http.StatusOK,
+			proxyService: &mocks.Getter{
+				GetFn: staleHit,
+			},
+		},
+		{
+			name:           "delete should return not found",
+			method:         "DELETE",
+			reqURL:         "http://test/cache?key=test",
+			expectedStatus: http.StatusNotFound,
+			proxyService:   newMockProxy(deleted),
+		},
+		{
+			name:           "flush should return not found",
+			method:         "POST",
+			reqURL:         "http://test/admin/flush",
+			expectedStatus: http.StatusNotFound,
+			proxyService:   newMockProxy(deleted),
+		},
 	}
 
 	for i := range scenarios {
-		req := httptest.NewRequest("GET", scenarios[i].reqURL, nil)
+		method := scenarios[i].method
+		if method == "" {
+			method = "GET"
+		}
+		req := httptest.NewRequest(method, scenarios[i].reqURL, nil)
 		w := httptest.NewRecorder()
 		handler := NewProxyHandler(scenarios[i].proxyService)
 		handler.ServeHTTP(w, req)
@@ -82,3 +175,56 @@ func TestAPIHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestAPIHandler_Stale(t *testing.T) {
+	before := httpStaleResponses.WithLabelValues().Value()
+
+	req := httptest.NewRequest("GET", "http://test/cache?key=test", nil)
+	w := httptest.NewRecorder()
+	NewProxyHandler(&mocks.Getter{GetFn: staleHit}).ServeHTTP(w, req)
+
+	resp := w.Result()
+	if w.Body.String() != "test" {
+		t.Fatalf("expected the stale value to be served, received: %q", w.Body.String())
+	}
+	warnings := resp.Header["Warning"]
+	if len(warnings) != 2 || warnings[0] != `110 - "Response is Stale"` {
+		t.Fatalf("expected the response to be marked as stale, received: %v", warnings)
+	}
+	if httpStaleResponses.WithLabelValues().Value() != before+1 {
+		t.Fatalf("expected the stale response to be counted")
+	}
+
+	req = httptest.NewRequest("GET", "http://test/cache?key=test", nil)
+	w = httptest.NewRecorder()
+	NewProxyHandler(&mocks.Getter{GetFn: cacheHit}).ServeHTTP(w, req)
+	if w.Result().Header.Get("Warning") != "" {
+		t.Fatalf("expected fresh responses not to be marked as stale")
+	}
+}
+
+// contextProxy is a proxy service
+// which accepts a context.
+type contextProxy struct {
+	*mocks.Getter
+	*mocks.ContextGetter
+}
+
+func TestAPIHandler_Context(t *testing.T) {
+	type ctxKey struct{}
+	req := httptest.NewRequest("GET", "http://test/cache?key=test", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ctxKey{}, "request"))
+
+	ps := &contextProxy{
+		Getter: &mocks.Getter{GetFn: cacheHit},
+		ContextGetter: &mocks.ContextGetter{GetContextFn: func(ctx context.Context, key string) (string, error) {
+			return ctx.Value(ctxKey{}).(string), nil
+		}},
+	}
+	w := httptest.NewRecorder()
+	NewProxyHandler(ps).ServeHTTP(w, req)
+
+	if ps.GetFnInvoked || w.Body.String() != "request" {
+		t.Fatalf("expected the request context to be passed on, received: %q", w.Body.String())
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const apiPathStats = "/stats"
+
+// StatsHandler serves the stats
+// of a service as JSON.
+type StatsHandler struct {
+	stats func() interface{}
+}
+
+// ensure that the handler implements
+// the http.Handler interface
+var _ = http.Handler(&StatsHandler{})
+
+// NewStatsHandler initializes a new StatsHandler.
+// It accepts the func which returns a snapshot of
+// the stats as a parameter.
+func NewStatsHandler(stats func() interface{}) *StatsHandler {
+	return &StatsHandler{
+		stats: stats,
+	}
+}
+
+// ServeHTTP implements the http handler for the stats.
+func (sh *StatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET" && r.URL.Path == apiPathStats:
+		sh.handleGetRequest(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (sh *StatsHandler) handleGetRequest(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(sh.stats())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
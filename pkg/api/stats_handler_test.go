@@ -0,0 +1,31 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsHandler(t *testing.T) {
+	handler := NewStatsHandler(func() interface{} {
+		return map[string]int{"hits": 1}
+	})
+
+	req := httptest.NewRequest("GET", "http://test/stats", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != `{"hits":1}` {
+		t.Fatalf("expected the stats to be served as json, received: %d %s", resp.StatusCode, body)
+	}
+
+	req = httptest.NewRequest("POST", "http://test/stats", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("expected invalid method to return not found")
+	}
+}
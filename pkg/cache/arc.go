@@ -0,0 +1,350 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// arcList identifies the list
+// an arc item belongs to.
+type arcList int
+
+const (
+	// t1 holds the keys seen once recently.
+	t1 arcList = iota
+
+	// t2 holds the keys seen at least twice recently.
+	t2
+
+	// b1 holds the ghost keys evicted from t1.
+	b1
+
+	// b2 holds the ghost keys evicted from t2.
+	b2
+)
+
+// arcItem represents a single cache
+// entry for an ARC cache. ghost items
+// only keep their key.
+type arcItem struct {
+	*entry
+
+	// in is the list holding the item.
+	in arcList
+
+	// element points to the item
+	// in the list holding it.
+	element *list.Element
+}
+
+// arcCache implements the adaptive replacement cache
+// described in "ARC: A Self-Tuning, Low Overhead
+// Replacement Cache" by Megiddo and Modha.
+// It keeps the recently and frequently used keys in
+// separate lists, and uses the ghost keys evicted from
+// either of them to adapt the target size of the
+// recency list.
+type arcCache struct {
+	// counters tracks the stats
+	// for the cache.
+	counters
+
+	// evictHook reports the keys
+	// leaving the cache.
+	evictHook
+
+	// capacity is the max. size
+	// of the cache.
+	capacity int
+
+	// ttl defines the ttl for
+	// keys added to the cache.
+	ttl time.Duration
+
+	// clock is the source of time
+	// for the expiry of the keys.
+	clock Clock
+
+	// this is the mutex protecting the
+	// lookupTable, the lists and the
+	// target size. reads move the items
+	// between lists, so a plain mutex is used.
+	sync.Mutex
+	lookupTable map[string]*arcItem
+	lists       [4]*list.List
+
+	// closed is set once the cache is closed.
+	closed bool
+
+	// p is the target size of t1.
+	p int
+}
+
+// ensure that the arc cache supports
+// a ttl per key.
+var _ = TTLSetter(&arcCache{})
+var _ = CheckedSetter(&arcCache{})
+var _ = StatsReporter(&arcCache{})
+var _ = EvictNotifier(&arcCache{})
+var _ = EntryGetter(&arcCache{})
+
+// NewARCCache is used to initialize an ARC cache.
+// It accepts the capacity of the cache, time to live
+// for the objects in the cache, and the options.
+// Expired keys are removed when they're looked up, or
+// evicted at capacity. Unlike the LRU cache, there is
+// no background cleanup.
+func NewARCCache(c int, t time.Duration, opts ...PolicyOption) Cacher {
+	if c < 1 {
+		c = 1
+	}
+	ac := &arcCache{
+		capacity:    c,
+		ttl:         t,
+		clock:       newPolicyOptions(opts).clock,
+		lookupTable: make(map[string]*arcItem, 2*c),
+	}
+	for i := range ac.lists {
+		ac.lists[i] = list.New()
+	}
+	return ac
+}
+
+// Get looks up the key in the ARC cache, and moves
+// it to the frequently used list on a hit. It returns
+// an error if the key isn't present in the cache.
+func (ac *arcCache) Get(key string) (string, error) {
+	e, err := ac.GetEntry(key)
+	return e.Value, err
+}
+
+// GetEntry looks up the key in the ARC cache, and
+// returns its value along with its expiry.
+func (ac *arcCache) GetEntry(key string) (Entry, error) {
+	ac.Lock()
+	defer ac.unlock(ac)
+
+	if ac.closed {
+		return Entry{}, ErrClosed
+	}
+
+	it, ok := ac.lookupTable[key]
+	if !ok || it.in == b1 || it.in == b2 {
+		ac.hit(false)
+		return Entry{}, ErrKeyNotFound
+	}
+	if it.expired(ac.clock.Now().UTC()) {
+		ac.remove(it, EvictExpired)
+		atomic.AddUint64(&ac.lazyExpirations, 1)
+		ac.hit(false)
+		return Entry{}, ErrKeyNotFound
+	}
+
+	ac.move(it, t2)
+	ac.hit(true)
+	return Entry{Value: it.value, Expiry: it.expiry}, nil
+}
+
+// Set adds the key value pair to the cache, evicting
+// a key from either the recency or the frequency list
+// if it's at capacity.
+func (ac *arcCache) Set(k, v string) {
+	ac.SetWithTTL(k, v, ac.ttl)
+}
+
+// SetWithTTL adds the key value pair to the cache with
+// the given time to live. A ttl <= 0 falls back to the
+// ttl the cache was initialized with.
+func (ac *arcCache) SetWithTTL(k, v string, ttl time.Duration) {
+	ac.TrySet(k, v, ttl)
+}
+
+// TrySet is the counterpart of SetWithTTL, which
+// returns ErrClosed if the cache is closed.
+func (ac *arcCache) TrySet(k, v string, ttl time.Duration) error {
+	e := newEntry(k, v, ttl, ac.ttl, ac.clock.Now())
+
+	ac.Lock()
+	defer ac.unlock(ac)
+
+	if ac.closed {
+		return ErrClosed
+	}
+
+	it, ok := ac.lookupTable[k]
+	switch {
+	case ok && (it.in == t1 || it.in == t2):
+		it.entry = e
+		ac.move(it, t2)
+		return nil
+
+	case ok && it.in == b1:
+		// a hit on a ghost key evicted from t1
+		// implies t1 should've been larger.
+		ac.p = min(ac.capacity, ac.p+max(ac.lists[b2].Len()/ac.lists[b1].Len(), 1))
+		ac.replace(false)
+		it.entry = e
+		ac.move(it, t2)
+		return nil
+
+	case ok && it.in == b2:
+		// a hit on a ghost key evicted from t2
+		// implies t2 should've been larger.
+		ac.p = max(0, ac.p-max(ac.lists[b1].Len()/ac.lists[b2].Len(), 1))
+		ac.replace(true)
+		it.entry = e
+		ac.move(it, t2)
+		return nil
+	}
+
+	l1 := ac.lists[t1].Len() + ac.lists[b1].Len()
+	total := l1 + ac.lists[t2].Len() + ac.lists[b2].Len()
+	switch {
+	case l1 >= ac.capacity:
+		if ac.lists[t1].Len() < ac.capacity {
+			ac.remove(ac.lists[b1].Back().Value.(*arcItem), EvictCapacity)
+			ac.replace(false)
+		} else {
+			ac.remove(ac.lists[t1].Back().Value.(*arcItem), EvictCapacity)
+			atomic.AddUint64(&ac.evictions, 1)
+		}
+	case total >= ac.capacity:
+		if total >= 2*ac.capacity {
+			ac.remove(ac.lists[b2].Back().Value.(*arcItem), EvictCapacity)
+		}
+		ac.replace(false)
+	}
+
+	it = &arcItem{
+		entry: e,
+		in:    t1,
+	}
+	it.element = ac.lists[t1].PushFront(it)
+	ac.lookupTable[k] = it
+	return nil
+}
+
+// Delete removes the key, along with its
+// ghost key, from the cache. It reports if
+// the key was present.
+func (ac *arcCache) Delete(key string) bool {
+	ac.Lock()
+	defer ac.unlock(ac)
+
+	if ac.closed {
+		return false
+	}
+
+	it, ok := ac.lookupTable[key]
+	if !ok {
+		return false
+	}
+	ac.remove(it, EvictDeleted)
+	return it.in == t1 || it.in == t2
+}
+
+// Flush removes all the keys, along with
+// the ghost keys, from the cache.
+func (ac *arcCache) Flush() {
+	ac.Lock()
+	defer ac.Unlock()
+
+	if ac.closed {
+		return
+	}
+
+	ac.lookupTable = make(map[string]*arcItem, 2*ac.capacity)
+	for i := range ac.lists {
+		ac.lists[i].Init()
+	}
+	ac.p = 0
+}
+
+// Close releases the entries in the cache.
+// It is safe to call Close more than once.
+func (ac *arcCache) Close() error {
+	ac.Lock()
+	defer ac.Unlock()
+
+	ac.closed = true
+	ac.lookupTable = nil
+	for i := range ac.lists {
+		ac.lists[i].Init()
+	}
+	return nil
+}
+
+// Stats returns the stats for the cache.
+// Ghost keys aren't counted as items.
+func (ac *arcCache) Stats() Stats {
+	ac.Lock()
+	defer ac.Unlock()
+
+	s := ac.stats()
+	s.Items = ac.lists[t1].Len() + ac.lists[t2].Len()
+	return s
+}
+
+// replace evicts the least recently used key from
+// either t1 or t2 into the matching ghost list, based
+// on the target size of t1. inB2 denotes if the key
+// being added was found in b2. The caller must hold
+// the lock.
+func (ac *arcCache) replace(inB2 bool) {
+	if ac.lists[t1].Len()+ac.lists[t2].Len() < ac.capacity {
+		return
+	}
+
+	n1 := ac.lists[t1].Len()
+	if n1 > 0 && (n1 > ac.p || (inB2 && n1 == ac.p)) {
+		it := ac.lists[t1].Back().Value.(*arcItem)
+		ac.evicted(it.key, it.value, EvictCapacity)
+		ac.move(it, b1)
+		atomic.AddUint64(&ac.evictions, 1)
+	} else if ac.lists[t2].Len() > 0 {
+		it := ac.lists[t2].Back().Value.(*arcItem)
+		ac.evicted(it.key, it.value, EvictCapacity)
+		ac.move(it, b2)
+		atomic.AddUint64(&ac.evictions, 1)
+	}
+}
+
+// move moves an item to the front of the given list.
+// Items moved to a ghost list drop their value.
+// The caller must hold the lock.
+func (ac *arcCache) move(it *arcItem, to arcList) {
+	ac.lists[it.in].Remove(it.element)
+	if to == b1 || to == b2 {
+		it.entry = &entry{key: it.key}
+	}
+	it.in = to
+	it.element = ac.lists[to].PushFront(it)
+}
+
+// remove removes an item from the lookuptable and
+// its list, for the given reason. Ghost keys have
+// already been reported when they were evicted. The
+// caller must hold the lock.
+func (ac *arcCache) remove(it *arcItem, reason EvictReason) {
+	ac.lists[it.in].Remove(it.element)
+	delete(ac.lookupTable, it.key)
+	if it.in == t1 || it.in == t2 {
+		ac.evicted(it.key, it.value, reason)
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
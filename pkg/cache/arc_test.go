@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestARC_ScanResistance(t *testing.T) {
+	lc := NewARCCache(10, time.Hour*1)
+	c := lc.(*arcCache)
+
+	// key0 to key4 are accessed twice, which
+	// moves them to the frequency list.
+	for i := 0; i < 5; i++ {
+		c.Set(key(i), value(i))
+		c.Get(key(i))
+	}
+
+	// a scan over keys which are never
+	// accessed again.
+	for i := 100; i < 200; i++ {
+		c.Set(key(i), value(i))
+	}
+
+	for i := 0; i < 5; i++ {
+		if val, err := c.Get(key(i)); val != value(i) || err != nil {
+			t.Fatalf("expected frequently used value to survive a scan, key: %s", key(i))
+		}
+	}
+	if c.lists[t1].Len()+c.lists[t2].Len() > 10 || len(c.lookupTable) > 20 {
+		t.Fatalf("expected the cache to hold at most its capacity and as many ghost keys")
+	}
+}
+
+func TestARC_GhostHitAdaptsTarget(t *testing.T) {
+	lc := NewARCCache(4, time.Hour*1)
+	c := lc.(*arcCache)
+
+	for i := 0; i < 4; i++ {
+		c.Set(key(i), value(i))
+	}
+	c.Get(key(3))
+	c.Set(key(4), value(4))
+
+	// key0 was evicted from t1 into b1. adding it
+	// again grows the target size for t1, and moves
+	// the key into t2.
+	if it, ok := c.lookupTable[key(0)]; !ok || it.in != b1 {
+		t.Fatalf("expected evicted key to be kept as a ghost key")
+	}
+	if _, err := c.Get(key(0)); err != ErrKeyNotFound {
+		t.Fatalf("expected ghost key to not be returned")
+	}
+
+	c.Set(key(0), value(0))
+	if c.p != 1 || c.lookupTable[key(0)].in != t2 {
+		t.Fatalf("expected a ghost hit in b1 to grow the target size for t1, p: %d", c.p)
+	}
+	if val, err := c.Get(key(0)); val != value(0) || err != nil {
+		t.Fatalf("expected value to be found after a ghost hit")
+	}
+}
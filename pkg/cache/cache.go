@@ -1,12 +1,24 @@
 package cache
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
 
 // Cacher defines the interface for a generic
 // cache that supports both reads and writes.
+// Close releases the resources held by the cache.
+// After Close, Get returns ErrClosed and Set is
+// a no-op, which a CheckedSetter reports with
+// ErrClosed.
 type Cacher interface {
 	Getter
 	Setter
+	Deleter
+	Flusher
+	io.Closer
 }
 
 // Getter defines the behavior for a
@@ -15,12 +27,93 @@ type Getter interface {
 	Get(key string) (string, error)
 }
 
+// TTLGetter defines the behavior for a
+// read-only store which reports the remaining
+// time to live for a key. A ttl of 0 implies
+// that the key doesn't expire.
+type TTLGetter interface {
+	GetWithTTL(key string) (string, time.Duration, error)
+}
+
+// ContextGetter defines the behavior for a
+// read-only store which stops waiting for a
+// key once the context is done.
+type ContextGetter interface {
+	GetContext(ctx context.Context, key string) (string, error)
+}
+
+// ContextTTLGetter is the TTLGetter
+// counterpart of ContextGetter.
+type ContextTTLGetter interface {
+	GetWithTTLContext(ctx context.Context, key string) (string, time.Duration, error)
+}
+
+// Entry is a value in a store, along
+// with the time at which it expires.
+type Entry struct {
+	Value  string
+	Expiry time.Time
+}
+
+// EntryGetter defines the behavior for a
+// read-only store which reports the expiry
+// of a key along with its value.
+type EntryGetter interface {
+	GetEntry(key string) (Entry, error)
+}
+
 // Setter defines the behavior for a
 // write-only store.
 type Setter interface {
 	Set(key, value string)
 }
 
+// Deleter defines the behavior for a
+// store which supports removing keys.
+// Delete reports if the key was present.
+type Deleter interface {
+	Delete(key string) bool
+}
+
+// Flusher defines the behavior for a
+// store which supports removing all keys.
+type Flusher interface {
+	Flush()
+}
+
+// TTLSetter defines the behavior for a
+// store which supports a time to live
+// per key. A ttl <= 0 falls back to the
+// default ttl of the store. Keys without
+// either never expire.
+type TTLSetter interface {
+	SetWithTTL(key, value string, ttl time.Duration)
+}
+
+// CheckedSetter defines the behavior for a
+// store which reports when a key can't be set,
+// e.g. with ErrClosed. A ttl <= 0 falls back
+// to the default ttl of the store.
+type CheckedSetter interface {
+	TrySet(key, value string, ttl time.Duration) error
+}
+
 // ErrKeyNotFound is the error returned when the
 // key is not present in the store.
 var ErrKeyNotFound = errors.New("cache: key not found")
+
+// ErrClosed is the error returned when
+// the cache is used after it was closed.
+var ErrClosed = errors.New("cache: closed")
+
+// StaleError is the error returned along with
+// a value which has expired, when the value
+// couldn't be refreshed. Err is the error which
+// prevented the refresh.
+type StaleError struct {
+	Err error
+}
+
+func (e *StaleError) Error() string {
+	return "cache: stale value, refresh error: " + e.Err.Error()
+}
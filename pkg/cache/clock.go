@@ -0,0 +1,27 @@
+package cache
+
+import "time"
+
+// Clock defines the source of time for a cache.
+// It allows tests to control the expiry of keys
+// and the background cleanup.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and
+	// then sends the current time on the channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock backed
+// by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
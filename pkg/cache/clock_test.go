@@ -0,0 +1,186 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vikramsk/rediproxy/pkg/cache"
+	"github.com/vikramsk/rediproxy/pkg/internal/mocks"
+)
+
+func TestClock_KeyExpiry(t *testing.T) {
+	clock := mocks.NewClock(time.Now())
+	c := cache.NewLRUCache(100, time.Minute*1, cache.WithClock(clock))
+	defer c.Close()
+
+	c.Set("key", "value")
+	c.(cache.TTLSetter).SetWithTTL("short", "value", time.Second*1)
+
+	clock.Advance(time.Second * 2)
+	if _, err := c.Get("short"); err != cache.ErrKeyNotFound {
+		t.Fatalf("expected key to be deleted after its own expiry")
+	}
+	if val, err := c.Get("key"); val != "value" || err != nil {
+		t.Fatalf("expected key to be present before the default expiry")
+	}
+
+	clock.Advance(time.Minute * 1)
+	if _, err := c.Get("key"); err != cache.ErrKeyNotFound {
+		t.Fatalf("expected key to be deleted after the default expiry")
+	}
+}
+
+func TestClock_PromotionWindow(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		elapsed  time.Duration
+		promoted bool
+	}{
+		{
+			name:     "within the window",
+			elapsed:  time.Minute * 2,
+			promoted: false,
+		},
+		{
+			name:     "past the window",
+			elapsed:  time.Minute * 4,
+			promoted: true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			clock := mocks.NewClock(time.Now())
+
+			// the window is 5% of the ttl, i.e 3 min.
+			c := cache.NewLRUCache(2, time.Hour*1, cache.WithClock(clock))
+			defer c.Close()
+
+			c.Set("a", "value")
+			c.Set("b", "value")
+
+			clock.Advance(s.elapsed)
+			c.Get("a")
+
+			// adding a key evicts the least
+			// recently used key.
+			c.Set("c", "value")
+
+			_, err := c.Get("a")
+			if promoted := err == nil; promoted != s.promoted {
+				t.Fatalf("expected promoted: %v, received: %v", s.promoted, promoted)
+			}
+		})
+	}
+}
+
+func TestClock_StaleDataCleanup(t *testing.T) {
+	clock := mocks.NewClock(time.Now())
+	c := cache.NewLRUCache(100, time.Second*1, cache.WithClock(clock))
+	defer c.Close()
+	sr := c.(cache.StatsReporter)
+
+	// wait for the first cleanup on the empty
+	// cache, before adding the keys.
+	clock.BlockUntil(1)
+	for i := 0; i < 50; i++ {
+		c.Set(cache.Key(i), cache.Value(i))
+	}
+
+	// the cleanup samples 20 keys at a time, and
+	// samples again while they have expired.
+	clock.Advance(time.Second * 2)
+	clock.BlockUntil(1)
+
+	s := sr.Stats()
+	if s.Items != 0 || s.Expirations != 50 || s.LazyExpirations != 0 {
+		t.Fatalf("expected keys to be expired by the cleanup, stats: %+v", s)
+	}
+	if s.SampleCycles != 2 || s.LastCycleChecked != 50 || s.LastCycleExpired != 50 {
+		t.Fatalf("expected the last cycle to expire every key, stats: %+v", s)
+	}
+}
+
+func TestClock_StaleDataCleanup_NoTTL(t *testing.T) {
+	clock := mocks.NewClock(time.Now())
+	c := cache.NewLRU(cache.WithClock(clock))
+	defer c.Close()
+	sr := c.(cache.StatsReporter)
+
+	clock.BlockUntil(1)
+	for i := 0; i < 10; i++ {
+		c.Set(cache.Key(i), cache.Value(i))
+		c.(cache.TTLSetter).SetWithTTL(cache.Key(i+10), cache.Value(i+10), time.Second*1)
+	}
+
+	// keys without a ttl aren't checked
+	// by the cleanup, and never expire.
+	clock.Advance(time.Second * 2)
+	clock.BlockUntil(1)
+
+	s := sr.Stats()
+	if s.Items != 10 || s.Expirations != 10 {
+		t.Fatalf("expected only the keys with a ttl to be expired, stats: %+v", s)
+	}
+	if s.LastCycleChecked != 10 || s.LastCycleExpired != 10 {
+		t.Fatalf("expected only the keys with a ttl to be checked, stats: %+v", s)
+	}
+}
+
+func TestClock_PolicyKeyExpiry(t *testing.T) {
+	for _, p := range []cache.Policy{cache.PolicyLFU, cache.PolicyARC, cache.PolicyTinyLFU} {
+		clock := mocks.NewClock(time.Now())
+		c, _ := cache.New(p, 100, time.Minute*1, cache.WithPolicyClock(clock))
+		defer c.Close()
+
+		c.Set("key", "value")
+		c.(cache.TTLSetter).SetWithTTL("short", "value", time.Second*1)
+
+		// the keys only expire on lookup.
+		clock.Advance(time.Second * 2)
+		if s := c.(cache.StatsReporter).Stats(); s.Items != 2 {
+			t.Fatalf("expected expired keys to be kept until they're looked up for policy: %s, stats: %+v", p, s)
+		}
+		if _, err := c.Get("short"); err != cache.ErrKeyNotFound {
+			t.Fatalf("expected key to expire after its own ttl for policy: %s", p)
+		}
+		if _, err := c.Get("key"); err != nil {
+			t.Fatalf("expected key to be found before the ttl for policy: %s", p)
+		}
+
+		clock.Advance(time.Minute * 1)
+		if _, err := c.Get("key"); err != cache.ErrKeyNotFound {
+			t.Fatalf("expected key to expire after the ttl for policy: %s", p)
+		}
+		if s := c.(cache.StatsReporter).Stats(); s.LazyExpirations != 2 || s.Items != 0 {
+			t.Fatalf("expected expired keys to be removed on lookup for policy: %s, stats: %+v", p, s)
+		}
+	}
+}
+
+func TestClock_EvictExpired(t *testing.T) {
+	clock := mocks.NewClock(time.Now())
+	c := cache.NewLRUCache(100, time.Second*1, cache.WithClock(clock))
+	defer c.Close()
+
+	reasons := make(chan cache.EvictReason, 10)
+	c.(cache.EvictNotifier).OnEvict(func(key, value string, reason cache.EvictReason) {
+		reasons <- reason
+	})
+
+	clock.BlockUntil(1)
+	c.Set(cache.Key(0), cache.Value(0))
+	c.Set(cache.Key(1), cache.Value(1))
+
+	// key0 is removed lazily, and key1
+	// is removed by the cleanup.
+	clock.Advance(time.Second * 2)
+	c.Get(cache.Key(0))
+	clock.BlockUntil(1)
+
+	for i := 0; i < 2; i++ {
+		if r := <-reasons; r != cache.EvictExpired {
+			t.Fatalf("expected expired keys to be reported, received: %s", r)
+		}
+	}
+}
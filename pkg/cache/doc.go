@@ -3,4 +3,6 @@
 // 1. The interfaces for reading, writing
 //    data from/to a caching store.
 // 2. An implementation of an LRU cache.
+// 3. Implementations of LFU, ARC and W-TinyLFU
+//    caches, selected with New.
 package cache
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EvictReason describes why
+// a key left the cache.
+type EvictReason int
+
+// The reasons passed to an EvictFunc.
+const (
+	// EvictCapacity implies that the key was
+	// evicted to make room for another key.
+	EvictCapacity EvictReason = iota + 1
+
+	// EvictExpired implies that the key was
+	// removed after its time to live.
+	EvictExpired
+
+	// EvictDeleted implies that the key
+	// was removed with Delete.
+	EvictDeleted
+)
+
+// String returns the name of the reason.
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// EvictFunc is called with the key value
+// pairs which leave the cache.
+type EvictFunc func(key, value string, reason EvictReason)
+
+// EvictNotifier defines the behavior for a cache
+// which reports the keys leaving the cache. Keys
+// removed by Flush or Close aren't reported.
+type EvictNotifier interface {
+	OnEvict(fn EvictFunc)
+}
+
+// eviction is a key value pair waiting
+// to be passed to the EvictFunc.
+type eviction struct {
+	key    string
+	value  string
+	reason EvictReason
+}
+
+// evictHook calls the EvictFunc of a cache.
+// Evictions are queued while the cache is
+// locked, and passed to the func once it's
+// unlocked, so that the func can use the cache.
+type evictHook struct {
+	fn atomic.Value
+
+	// pending is guarded by
+	// the lock of the cache.
+	pending []eviction
+}
+
+// OnEvict sets the func called with the keys
+// leaving the cache. A nil func disables it.
+func (h *evictHook) OnEvict(fn EvictFunc) {
+	h.fn.Store(fn)
+}
+
+// evicted queues a key value pair for the
+// func, if it's set. The caller must hold
+// the lock of the cache.
+func (h *evictHook) evicted(key, value string, reason EvictReason) {
+	if fn, _ := h.fn.Load().(EvictFunc); fn == nil {
+		return
+	}
+	h.pending = append(h.pending, eviction{key, value, reason})
+}
+
+// unlock releases the lock of the cache,
+// and passes the queued key value pairs
+// to the func.
+func (h *evictHook) unlock(l sync.Locker) {
+	pending := h.pending
+	h.pending = nil
+	l.Unlock()
+
+	fn, _ := h.fn.Load().(EvictFunc)
+	if fn == nil {
+		return
+	}
+	for _, e := range pending {
+		fn(e.key, e.value, e.reason)
+	}
+}
@@ -0,0 +1,8 @@
+package cache
+
+// Key and Value expose the key and value
+// helpers to the external tests.
+var (
+	Key   = key
+	Value = value
+)
@@ -0,0 +1,274 @@
+package cache
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lfuItem represents a single cache
+// entry for an LFU cache.
+type lfuItem struct {
+	*entry
+
+	// freq is the number of times
+	// the item has been accessed.
+	freq int
+
+	// tick is the logical time of the
+	// last access, used to evict the least
+	// recently used among equally frequent
+	// items.
+	tick uint64
+
+	// index is the position of
+	// the item in the heap.
+	index int
+}
+
+// lfuHeap is a min-heap of items ordered
+// by frequency, and then by the last access.
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq == h[j].freq {
+		return h[i].tick < h[j].tick
+	}
+	return h[i].freq < h[j].freq
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	it := x.(*lfuItem)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return it
+}
+
+type lfuCache struct {
+	// counters tracks the stats
+	// for the cache.
+	counters
+
+	// evictHook reports the keys
+	// leaving the cache.
+	evictHook
+
+	// capacity is the max. number
+	// of keys in the cache. a value
+	// <= 0 implies no limit on keys.
+	capacity int
+
+	// ttl defines the ttl for
+	// keys added to the cache.
+	ttl time.Duration
+
+	// clock is the source of time
+	// for the expiry of the keys.
+	clock Clock
+
+	// this is the mutex protecting the
+	// lookupTable and the heap. reads
+	// update the frequency of the items,
+	// so a plain mutex is used.
+	sync.Mutex
+	lookupTable map[string]*lfuItem
+	heap        lfuHeap
+	tick        uint64
+
+	// closed is set once the cache is closed.
+	closed bool
+}
+
+// ensure that the lfu cache supports
+// a ttl per key.
+var _ = TTLSetter(&lfuCache{})
+var _ = CheckedSetter(&lfuCache{})
+var _ = StatsReporter(&lfuCache{})
+var _ = EvictNotifier(&lfuCache{})
+var _ = EntryGetter(&lfuCache{})
+
+// NewLFUCache is used to initialize an LFU cache.
+// It accepts the capacity of the cache, time to live
+// for the objects in the cache, and the options.
+// Expired keys are removed when they're looked up, or
+// evicted at capacity. Unlike the LRU cache, there is
+// no background cleanup.
+func NewLFUCache(c int, t time.Duration, opts ...PolicyOption) Cacher {
+	return &lfuCache{
+		capacity:    c,
+		ttl:         t,
+		clock:       newPolicyOptions(opts).clock,
+		lookupTable: make(map[string]*lfuItem, c),
+	}
+}
+
+// Get looks up the key in the LFU cache and
+// increments its frequency. It returns an error
+// if the key isn't present in the cache.
+func (lc *lfuCache) Get(key string) (string, error) {
+	e, err := lc.GetEntry(key)
+	return e.Value, err
+}
+
+// GetEntry looks up the key in the LFU cache, and
+// returns its value along with its expiry.
+func (lc *lfuCache) GetEntry(key string) (Entry, error) {
+	lc.Lock()
+	defer lc.unlock(lc)
+
+	if lc.closed {
+		return Entry{}, ErrClosed
+	}
+
+	it, ok := lc.lookupTable[key]
+	if !ok {
+		lc.hit(false)
+		return Entry{}, ErrKeyNotFound
+	}
+	if it.expired(lc.clock.Now().UTC()) {
+		lc.remove(it, EvictExpired)
+		atomic.AddUint64(&lc.lazyExpirations, 1)
+		lc.hit(false)
+		return Entry{}, ErrKeyNotFound
+	}
+
+	lc.touch(it)
+	lc.hit(true)
+	return Entry{Value: it.value, Expiry: it.expiry}, nil
+}
+
+// Set adds the key value pair to the cache, evicting
+// the least frequently used key if it's at capacity.
+func (lc *lfuCache) Set(k, v string) {
+	lc.SetWithTTL(k, v, lc.ttl)
+}
+
+// SetWithTTL adds the key value pair to the cache with
+// the given time to live. A ttl <= 0 falls back to the
+// ttl the cache was initialized with.
+func (lc *lfuCache) SetWithTTL(k, v string, ttl time.Duration) {
+	lc.TrySet(k, v, ttl)
+}
+
+// TrySet is the counterpart of SetWithTTL, which
+// returns ErrClosed if the cache is closed.
+func (lc *lfuCache) TrySet(k, v string, ttl time.Duration) error {
+	e := newEntry(k, v, ttl, lc.ttl, lc.clock.Now())
+
+	lc.Lock()
+	defer lc.unlock(lc)
+
+	if lc.closed {
+		return ErrClosed
+	}
+
+	// an existing key keeps its frequency.
+	if it, ok := lc.lookupTable[k]; ok {
+		it.entry = e
+		lc.touch(it)
+		return nil
+	}
+
+	if lc.capacity > 0 && len(lc.lookupTable) >= lc.capacity {
+		lc.remove(lc.heap[0], EvictCapacity)
+		atomic.AddUint64(&lc.evictions, 1)
+	}
+
+	lc.tick++
+	it := &lfuItem{
+		entry: e,
+		freq:  1,
+		tick:  lc.tick,
+	}
+	heap.Push(&lc.heap, it)
+	lc.lookupTable[k] = it
+	return nil
+}
+
+// Delete removes the key from the cache.
+// It reports if the key was present.
+func (lc *lfuCache) Delete(key string) bool {
+	lc.Lock()
+	defer lc.unlock(lc)
+
+	if lc.closed {
+		return false
+	}
+
+	it, ok := lc.lookupTable[key]
+	if !ok {
+		return false
+	}
+	lc.remove(it, EvictDeleted)
+	return true
+}
+
+// Flush removes all the keys from the cache.
+func (lc *lfuCache) Flush() {
+	lc.Lock()
+	defer lc.Unlock()
+
+	if lc.closed {
+		return
+	}
+
+	lc.lookupTable = make(map[string]*lfuItem, lc.capacity)
+	lc.heap = nil
+}
+
+// Close releases the entries in the cache.
+// It is safe to call Close more than once.
+func (lc *lfuCache) Close() error {
+	lc.Lock()
+	defer lc.Unlock()
+
+	lc.closed = true
+	lc.lookupTable = nil
+	lc.heap = nil
+	return nil
+}
+
+// Stats returns the stats for the cache.
+func (lc *lfuCache) Stats() Stats {
+	lc.Lock()
+	defer lc.Unlock()
+
+	s := lc.stats()
+	s.Items = len(lc.lookupTable)
+	return s
+}
+
+// touch records an access for the item. The
+// caller must hold the lock.
+func (lc *lfuCache) touch(it *lfuItem) {
+	lc.tick++
+	it.freq++
+	it.tick = lc.tick
+	heap.Fix(&lc.heap, it.index)
+}
+
+// remove removes an item from the lookuptable and
+// the heap, for the given reason. The caller must
+// hold the lock.
+func (lc *lfuCache) remove(it *lfuItem, reason EvictReason) {
+	heap.Remove(&lc.heap, it.index)
+	delete(lc.lookupTable, it.key)
+	lc.evicted(it.key, it.value, reason)
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLFU_EvictLeastFrequent(t *testing.T) {
+	c := NewLFUCache(3, time.Hour*1)
+	for i := 0; i < 3; i++ {
+		c.Set(key(i), value(i))
+	}
+
+	// key0 and key2 are accessed more often than key1.
+	c.Get(key(0))
+	c.Get(key(2))
+
+	c.Set(key(3), value(3))
+	if _, err := c.Get(key(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected least frequently used value to be evicted")
+	}
+
+	// key3 and key1 had the same frequency, the
+	// least recently used of them is evicted first.
+	c.Set(key(4), value(4))
+	if _, err := c.Get(key(3)); err != ErrKeyNotFound {
+		t.Fatalf("expected least recently used value to be evicted on a tie")
+	}
+	for _, i := range []int{0, 2, 4} {
+		if val, err := c.Get(key(i)); val != value(i) || err != nil {
+			t.Fatalf("expected value to be found for key: %s", key(i))
+		}
+	}
+}
+
+func TestLFU_SetExistingKey(t *testing.T) {
+	lc := NewLFUCache(2, time.Hour*1)
+	c := lc.(*lfuCache)
+
+	c.Set(key(0), value(0))
+	c.Set(key(0), value(1))
+	if val, err := c.Get(key(0)); val != value(1) || err != nil {
+		t.Fatalf("expected the value for the key to be replaced")
+	}
+	if len(c.heap) != 1 || c.lookupTable[key(0)].freq != 3 {
+		t.Fatalf("expected the replaced key to keep its frequency")
+	}
+}
@@ -3,10 +3,11 @@ package cache
 import (
 	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// defaultWindowPercent is used to specify
+// DefaultPromotionWindow is used to specify
 // the min time window which needs to exist
 // between moving an element from the linked
 // list to the front.
@@ -20,12 +21,26 @@ import (
 // implies that time difference between
 // successive moves of an element to the
 // front will at least be 3 min.
-const defaultWindowPercent = 0.05
+const DefaultPromotionWindow = 0.05
 
-// defaultSampleSize is used to randomly pick
+// DefaultSampleSize is used to randomly pick
 // elements from the cache and evict them if
 // they have expired.
-const defaultSampleSize = 20
+const DefaultSampleSize = 20
+
+// DefaultSampleInterval is the time between
+// consecutive runs of the background cleanup.
+const DefaultSampleInterval = time.Second / 10
+
+// DefaultSampleBudget is the max. time spent
+// by a run of the background cleanup, when it
+// repeats the sampling.
+const DefaultSampleBudget = time.Millisecond * 25
+
+// DefaultRepeatThreshold is the fraction of expired
+// keys in a sample above which the background cleanup
+// samples again right away.
+const DefaultRepeatThreshold = 0.25
 
 // item represents a single cache
 // entry for an LRU cache.
@@ -38,9 +53,14 @@ type item struct {
 	// the front of the list.
 	movedAt time.Time
 
+	// size is the number of bytes
+	// used by the key and the value.
+	size int64
+
 	// expiry refers to the time
 	// at which the key is invalid.
-	// times are stored in UTC.
+	// times are stored in UTC. it's
+	// zero if the key never expires.
 	expiry time.Time
 
 	// element points to the item
@@ -50,12 +70,33 @@ type item struct {
 }
 
 type lruCache struct {
-	// capacity is the max. size
-	// of the cache.
+	// counters tracks the stats
+	// for the cache.
+	counters
+
+	// evictHook reports the keys
+	// leaving the cache.
+	evictHook
+
+	// capacity is the max. number
+	// of keys in the cache. a value
+	// <= 0 implies no limit on keys.
 	capacity int
 
+	// maxBytes is the max. number of
+	// bytes used by the entries in the
+	// cache. a value <= 0 implies no
+	// limit on bytes.
+	maxBytes int64
+
+	// maxItemBytes is the max. number of
+	// bytes for a single entry. entries
+	// larger than this are rejected.
+	maxItemBytes int64
+
 	// ttl defines the ttl for
-	// keys added to the cache.
+	// keys added to the cache. a
+	// value <= 0 implies no expiry.
 	ttl time.Duration
 
 	// timeWindow is the duration
@@ -63,73 +104,387 @@ type lruCache struct {
 	// for the same element in the linked
 	// list used to track LRU behavior.
 	// this value is set using the
-	// defaultWindowPercent
-	timeWindow time.Duration
+	// windowPercent.
+	timeWindow    time.Duration
+	windowPercent float64
+
+	// sampleSize, sampleInterval,
+	// sampleBudget and repeatThreshold
+	// configure the background cleanup.
+	// check runCleanup for details.
+	sampleSize      int
+	sampleInterval  time.Duration
+	sampleBudget    time.Duration
+	repeatThreshold float64
+
+	// initialSize is the initial size
+	// of the lookupTable.
+	initialSize int
 
 	// this is the mutex protecting the
 	// lookupTable and the list.
 	sync.RWMutex
 	lookupTable map[string]*item
 	list        *list.List
+
+	// bytes is the number of bytes used
+	// by the entries in the cache.
+	bytes int64
+
+	// clock is the source of time
+	// for the expiry of the keys and
+	// the background cleanup.
+	clock Clock
+
+	// closed is set once the cache is closed.
+	// done is closed to stop the background
+	// cleanup.
+	closed bool
+	done   chan struct{}
 }
 
-// NewLRUCache is used to initialize an LRU cache.
-// It accepts the capacity of the cache, time to live
-// for the objects in the cache.
-func NewLRUCache(c int, t time.Duration) Cacher {
-	tw := time.Duration(int(defaultWindowPercent * float64(t)))
+// ensure that the lru cache supports
+// a ttl per key and reports its stats
+// and evictions.
+var _ = TTLSetter(&lruCache{})
+var _ = CheckedSetter(&lruCache{})
+var _ = StatsReporter(&lruCache{})
+var _ = EvictNotifier(&lruCache{})
+var _ = EntryGetter(&lruCache{})
+
+// Option configures an LRU cache.
+type Option func(*lruCache)
+
+// WithCapacity sets the max. number of keys
+// in the cache. A value <= 0 implies no limit.
+func WithCapacity(c int) Option {
+	return func(lc *lruCache) {
+		lc.capacity = c
+	}
+}
+
+// WithMaxBytes sets the max. number of bytes used
+// by the entries in the cache, and the max. number
+// of bytes for a single entry. A value <= 0 implies
+// no limit.
+func WithMaxBytes(maxBytes, maxItemBytes int64) Option {
+	return func(lc *lruCache) {
+		lc.maxBytes = maxBytes
+		lc.maxItemBytes = maxItemBytes
+	}
+}
+
+// WithTTL sets the time to live for the
+// objects in the cache. A value <= 0 implies
+// that they never expire.
+func WithTTL(t time.Duration) Option {
+	return func(lc *lruCache) {
+		lc.ttl = t
+	}
+}
+
+// WithPromotionWindow sets the min. time between
+// moves of a key to the front of the list, as a
+// fraction of the ttl. It defaults to 0.05.
+func WithPromotionWindow(percent float64) Option {
+	return func(lc *lruCache) {
+		if percent >= 0 {
+			lc.windowPercent = percent
+		}
+	}
+}
+
+// WithSampleSize sets the number of keys checked
+// for expiry by each run of the background cleanup.
+// It defaults to 20.
+func WithSampleSize(n int) Option {
+	return func(lc *lruCache) {
+		if n > 0 {
+			lc.sampleSize = n
+		}
+	}
+}
+
+// WithSampleInterval sets the time between runs of
+// the background cleanup. It defaults to 100ms.
+func WithSampleInterval(d time.Duration) Option {
+	return func(lc *lruCache) {
+		if d > 0 {
+			lc.sampleInterval = d
+		}
+	}
+}
+
+// WithSampleBudget sets the max. time spent by a run
+// of the background cleanup, which keeps sampling while
+// more than the repeat threshold of the sampled keys
+// have expired. A budget of 0 limits a run to a single
+// sample. It defaults to 25ms.
+func WithSampleBudget(d time.Duration) Option {
+	return func(lc *lruCache) {
+		if d >= 0 {
+			lc.sampleBudget = d
+		}
+	}
+}
+
+// WithRepeatThreshold sets the fraction of expired
+// keys in a sample above which the background cleanup
+// samples again, within its sample budget. It defaults
+// to 0.25.
+func WithRepeatThreshold(f float64) Option {
+	return func(lc *lruCache) {
+		if f >= 0 && f <= 1 {
+			lc.repeatThreshold = f
+		}
+	}
+}
+
+// WithInitialSize sets the number of keys the cache
+// allocates room for upfront. It defaults to the
+// capacity.
+func WithInitialSize(n int) Option {
+	return func(lc *lruCache) {
+		if n >= 0 {
+			lc.initialSize = n
+		}
+	}
+}
+
+// WithClock sets the source of time for the cache.
+// It defaults to the time package.
+func WithClock(c Clock) Option {
+	return func(lc *lruCache) {
+		lc.clock = c
+	}
+}
+
+// WithEvictionCallback sets the func called with
+// the keys leaving the cache. check OnEvict for
+// details.
+func WithEvictionCallback(fn EvictFunc) Option {
+	return func(lc *lruCache) {
+		lc.OnEvict(fn)
+	}
+}
+
+// NewLRU is used to initialize an LRU cache
+// configured by the options. The cache has no
+// limits, and its keys never expire, unless
+// they are set.
+func NewLRU(opts ...Option) Cacher {
 	lc := &lruCache{
-		capacity:    c,
-		ttl:         t,
-		timeWindow:  tw,
-		lookupTable: make(map[string]*item, c),
-		list:        list.New(),
+		windowPercent:   DefaultPromotionWindow,
+		sampleSize:      DefaultSampleSize,
+		sampleInterval:  DefaultSampleInterval,
+		sampleBudget:    DefaultSampleBudget,
+		repeatThreshold: DefaultRepeatThreshold,
+		initialSize:     -1,
+		clock:           realClock{},
+		list:            list.New(),
+		done:            make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(lc)
+	}
+
+	if lc.initialSize < 0 {
+		lc.initialSize = max(lc.capacity, 0)
+	}
+	lc.timeWindow = time.Duration(int(lc.windowPercent * float64(lc.ttl)))
+	lc.lookupTable = make(map[string]*item, lc.initialSize)
+
 	go lc.runCleanup()
 	return lc
 }
 
+// NewLRUCache is used to initialize an LRU cache.
+// It accepts the capacity of the cache, time to live
+// for the objects in the cache, and the options.
+func NewLRUCache(c int, t time.Duration, opts ...Option) Cacher {
+	return NewLRU(append([]Option{WithCapacity(c), WithTTL(t)}, opts...)...)
+}
+
+// NewSizedLRUCache is used to initialize an LRU cache
+// which is limited by the memory used by its entries,
+// instead of the number of keys. It accepts the max. bytes
+// for the cache, the max. bytes for a single entry and the
+// time to live for the objects in the cache, and the
+// options. The size of an entry is the size of its key
+// and value.
+func NewSizedLRUCache(maxBytes, maxItemBytes int64, t time.Duration, opts ...Option) Cacher {
+	return NewLRU(append([]Option{WithMaxBytes(maxBytes, maxItemBytes), WithTTL(t)}, opts...)...)
+}
+
 // Get looks up the key in the in-memory LRU cache.
 // It returns an error if the key isn't present in
 // the cache.
 func (lc *lruCache) Get(key string) (string, error) {
+	e, err := lc.GetEntry(key)
+	return e.Value, err
+}
+
+// GetEntry looks up the key in the in-memory LRU cache,
+// and returns its value along with its expiry. It returns
+// an error if the key isn't present in the cache.
+func (lc *lruCache) GetEntry(key string) (Entry, error) {
 	it, move, del, err := lc.searchItem(key)
-	if err != nil {
-		return "", err
+	if err == ErrClosed {
+		return Entry{}, err
+	} else if err != nil {
+		lc.hit(false)
+		return Entry{}, err
 	} else if del {
-		lc.removeItem(it)
-		return "", ErrKeyNotFound
+		if lc.removeItem(it, EvictExpired) {
+			atomic.AddUint64(&lc.lazyExpirations, 1)
+		}
+		lc.hit(false)
+		return Entry{}, ErrKeyNotFound
 	}
 
 	if move {
 		lc.moveItemFront(it)
 	}
-	return it.value, nil
+	lc.hit(true)
+	return Entry{Value: it.value, Expiry: it.expiry}, nil
 }
 
 // Set adds the key value pair to the cache, ensuring
 // that it adheres to the constraints on the capacity.
 func (lc *lruCache) Set(k, v string) {
+	lc.SetWithTTL(k, v, lc.ttl)
+}
+
+// SetWithTTL adds the key value pair to the cache with
+// the given time to live. A ttl <= 0 falls back to the
+// ttl the cache was initialized with, and the key never
+// expires if that is <= 0 as well.
+func (lc *lruCache) SetWithTTL(k, v string, ttl time.Duration) {
+	lc.TrySet(k, v, ttl)
+}
+
+// TrySet is the counterpart of SetWithTTL, which
+// returns ErrClosed if the cache is closed.
+func (lc *lruCache) TrySet(k, v string, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = lc.ttl
+	}
+
+	now := lc.clock.Now().UTC()
 	i := &item{
 		key:     k,
 		value:   v,
-		movedAt: time.Now().UTC(),
-		expiry:  time.Now().UTC().Add(lc.ttl),
+		size:    int64(len(k) + len(v)),
+		movedAt: now,
+	}
+	if ttl > 0 {
+		i.expiry = now.Add(ttl)
 	}
 
 	lc.Lock()
-	defer lc.Unlock()
+	defer lc.unlock(lc)
+
+	if lc.closed {
+		return ErrClosed
+	}
+
+	// replace the existing entry for the key,
+	// if any, so that it doesn't linger in the list.
+	if old, ok := lc.lookupTable[k]; ok {
+		lc.unlink(old)
+	}
+
+	if lc.isTooLarge(i) {
+		atomic.AddUint64(&lc.rejections, 1)
+		return nil
+	}
 
-	if lc.isFull() {
+	for lc.isFull(i) {
 		it := lc.list.Back().Value.(*item)
-		delete(lc.lookupTable, it.key)
-		lc.list.Remove(lc.list.Back())
+		lc.unlink(it)
+		atomic.AddUint64(&lc.evictions, 1)
+		lc.evicted(it.key, it.value, EvictCapacity)
 	}
 
 	elem := lc.list.PushFront(i)
 	i.element = elem
 
 	lc.lookupTable[k] = i
+	lc.bytes += i.size
+	return nil
+}
+
+// Delete removes the key from the cache.
+// It reports if the key was present.
+func (lc *lruCache) Delete(key string) bool {
+	lc.Lock()
+	defer lc.unlock(lc)
+
+	if lc.closed {
+		return false
+	}
+
+	it, ok := lc.lookupTable[key]
+	if !ok {
+		return false
+	}
+	lc.unlink(it)
+	lc.evicted(it.key, it.value, EvictDeleted)
+	return true
+}
+
+// Flush removes all the keys from the cache.
+func (lc *lruCache) Flush() {
+	lc.Lock()
+	defer lc.Unlock()
+
+	if lc.closed {
+		return
+	}
+
+	// detach the items, so that lookups
+	// in flight don't move them back.
+	for _, it := range lc.lookupTable {
+		it.element = nil
+	}
+	lc.lookupTable = make(map[string]*item, len(lc.lookupTable))
+	lc.list.Init()
+	lc.bytes = 0
+}
+
+// Stats returns the stats for the cache.
+func (lc *lruCache) Stats() Stats {
+	s := lc.stats()
+
+	lc.RLock()
+	defer lc.RUnlock()
+	s.Items = len(lc.lookupTable)
+	s.Bytes = lc.bytes
+	return s
+}
+
+// Close stops the background cleanup and releases
+// the entries in the cache. It is safe to call Close
+// more than once.
+func (lc *lruCache) Close() error {
+	lc.Lock()
+	defer lc.Unlock()
+
+	if lc.closed {
+		return nil
+	}
+	lc.closed = true
+	close(lc.done)
+
+	// detach the items, so that lookups
+	// in flight don't move them back.
+	for _, it := range lc.lookupTable {
+		it.element = nil
+	}
+	lc.lookupTable = nil
+	lc.list = nil
+	lc.bytes = 0
+	return nil
 }
 
 // searchKey looks up the key in the cache.
@@ -145,14 +500,18 @@ func (lc *lruCache) searchItem(key string) (*item, bool, bool, error) {
 	lc.RLock()
 	defer lc.RUnlock()
 
+	if lc.closed {
+		return nil, false, false, ErrClosed
+	}
+
 	if it, ok = lc.lookupTable[key]; !ok {
 		return nil, false, false, ErrKeyNotFound
 	}
 
-	now := time.Now().UTC()
+	now := lc.clock.Now().UTC()
 
 	// check if item has expired
-	if it.expiry.Sub(now) < 0 {
+	if !it.expiry.IsZero() && it.expiry.Sub(now) < 0 {
 		return it, false, true, nil
 	}
 
@@ -164,27 +523,55 @@ func (lc *lruCache) searchItem(key string) (*item, bool, bool, error) {
 	return it, false, false, nil
 }
 
-// isFull checks if the lru cache has
-// hit the capacity.
-func (lc *lruCache) isFull() bool {
-	if len(lc.lookupTable) == lc.capacity {
+// isFull checks if the lru cache has hit the
+// capacity, and needs to evict items to make
+// room for the given item.
+func (lc *lruCache) isFull(i *item) bool {
+	if lc.list.Len() == 0 {
+		return false
+	}
+	if lc.capacity > 0 && len(lc.lookupTable) >= lc.capacity {
+		return true
+	}
+	if lc.maxBytes > 0 && lc.bytes+i.size > lc.maxBytes {
+		return true
+	}
+	return false
+}
+
+// isTooLarge checks if the item can never
+// fit in the lru cache, and must be rejected.
+func (lc *lruCache) isTooLarge(i *item) bool {
+	if lc.maxItemBytes > 0 && i.size > lc.maxItemBytes {
+		return true
+	}
+	if lc.maxBytes > 0 && i.size > lc.maxBytes {
 		return true
 	}
 	return false
 }
 
 // removeItem removes an item from the lookuptable
-// and the list.
-func (lc *lruCache) removeItem(i *item) {
+// and the list, for the given reason. It reports if
+// the item was removed.
+func (lc *lruCache) removeItem(i *item, reason EvictReason) bool {
 	lc.Lock()
-	defer lc.Unlock()
+	defer lc.unlock(lc)
 	if i.element == nil {
-		return
+		return false
 	}
+	lc.unlink(i)
+	lc.evicted(i.key, i.value, reason)
+	return true
+}
+
+// unlink removes an item from the lookuptable and
+// the list. The caller must hold the write lock.
+func (lc *lruCache) unlink(i *item) {
 	lc.list.Remove(i.element)
 	delete(lc.lookupTable, i.key)
+	lc.bytes -= i.size
 	i.element = nil
-
 }
 
 // moveItemFront detaches an item from the list
@@ -196,31 +583,54 @@ func (lc *lruCache) moveItemFront(i *item) {
 		return
 	}
 	lc.list.Remove(i.element)
-	i.movedAt = time.Now().UTC()
+	i.movedAt = lc.clock.Now().UTC()
 	elem := lc.list.PushFront(i)
 	i.element = elem
 }
 
 // runCleanup is a background worker that picks
 // up random keys from the cache and checks if
-// they have expired. It runs the removeStaleData
-// func 10 times in 1 second.
+// they have expired. It runs a cycle of the
+// removeStaleData func every sampleInterval, until
+// the cache is closed. A cycle samples new random keys
+// right away while more than the repeatThreshold of the
+// sampled keys have expired, until it runs out of its
+// sampleBudget.
 // This is inspired by the Redis EXPIRE strategy.
 // https://redis.io/commands/expire#how-redis-expires-keys
 func (lc *lruCache) runCleanup() {
-	var keys map[string]struct{}
 	for {
-		if len(keys) <= 0.75*defaultSampleSize {
-			keys = lc.getRandomKeys(defaultSampleSize)
+		var checked, expired int
+		start := lc.clock.Now()
+		for {
+			c, e := lc.removeStaleData(lc.getRandomKeys(lc.sampleSize))
+			checked += c
+			expired += e
+			atomic.AddUint64(&lc.sampleRounds, 1)
+
+			if float64(e) <= lc.repeatThreshold*float64(c) ||
+				lc.clock.Now().Sub(start) >= lc.sampleBudget {
+				break
+			}
+		}
+		atomic.AddUint64(&lc.sampleCycles, 1)
+		atomic.AddUint64(&lc.sampleChecked, uint64(checked))
+		atomic.StoreUint64(&lc.lastCycleChecked, uint64(checked))
+		atomic.StoreUint64(&lc.lastCycleExpired, uint64(expired))
+
+		select {
+		case <-lc.done:
+			return
+		case <-lc.clock.After(lc.sampleInterval):
 		}
-		lc.removeStaleData(keys)
-		time.Sleep(time.Duration(1e9 / 10))
 	}
 }
 
 // removeStaleData iterates through the provided keys
-// and removed any expired items from the cache.
-func (lc *lruCache) removeStaleData(keys map[string]struct{}) {
+// and removed any expired items from the cache. It
+// returns the number of keys checked and expired.
+func (lc *lruCache) removeStaleData(keys map[string]struct{}) (int, int) {
+	var checked, expired int
 	for k, _ := range keys {
 		it, _, del, err := lc.searchItem(k)
 		// key has already been deleted
@@ -228,11 +638,20 @@ func (lc *lruCache) removeStaleData(keys map[string]struct{}) {
 			delete(keys, k)
 			continue
 		}
+		// keys without a ttl never expire.
+		if it.expiry.IsZero() {
+			continue
+		}
+		checked++
 		if del {
 			delete(keys, k)
-			lc.removeItem(it)
+			if lc.removeItem(it, EvictExpired) {
+				atomic.AddUint64(&lc.expirations, 1)
+				expired++
+			}
 		}
 	}
+	return checked, expired
 }
 
 // getRandomKeys fetches random keys from the lookupTable
@@ -3,6 +3,7 @@ package cache
 import (
 	"fmt"
 	"math/rand"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -17,6 +18,7 @@ func value(i int) string {
 
 func TestGetOldest_EvictOldest(t *testing.T) {
 	lc := NewLRUCache(100, time.Minute*1)
+	defer lc.Close()
 
 	// this is done in order to ensure that
 	// we skip the lazy promotion flow.
@@ -45,6 +47,7 @@ func TestGetOldest_EvictOldest(t *testing.T) {
 
 func TestKeyExpiry(t *testing.T) {
 	c := NewLRUCache(100, time.Millisecond*1)
+	defer c.Close()
 	for i := 0; i < 100; i++ {
 		c.Set(key(i), value(i))
 	}
@@ -62,6 +65,7 @@ func TestKeyExpiry(t *testing.T) {
 
 func TestLazyKeyPromotion(t *testing.T) {
 	c := NewLRUCache(100, time.Hour*1)
+	defer c.Close()
 	for i := 0; i < 100; i++ {
 		c.Set(key(i), value(i))
 	}
@@ -80,6 +84,7 @@ func TestLazyKeyPromotion(t *testing.T) {
 
 func TestStaleDataCleanup(t *testing.T) {
 	lc := NewLRUCache(100, time.Millisecond*1)
+	defer lc.Close()
 	for i := 0; i < 50; i++ {
 		lc.Set(key(i), value(i))
 	}
@@ -96,6 +101,7 @@ func TestStaleDataCleanup(t *testing.T) {
 
 func BenchmarkLRURandom(b *testing.B) {
 	lc := NewLRUCache(8192, time.Hour*1)
+	defer lc.Close()
 
 	trace := make([]string, b.N*2)
 	for i := 0; i < b.N*2; i++ {
@@ -123,6 +129,7 @@ func BenchmarkLRURandom(b *testing.B) {
 // Frequent benchmark works by frequently
 func BenchmarkLRUFrequent(b *testing.B) {
 	lc := NewLRUCache(8192, time.Hour*1)
+	defer lc.Close()
 
 	unique := make(map[string]struct{}, 0)
 	trace := make([]int, b.N*2)
@@ -158,3 +165,274 @@ func BenchmarkLRUFrequent(b *testing.B) {
 	}
 	b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(miss))
 }
+
+// BenchmarkMassExpiry measures the memory reclaimed by
+// the background cleanup after the short lived keys have
+// expired, with and without repeating the sampling. The
+// mixed ttl case sets a few long lived keys first.
+func BenchmarkMassExpiry(b *testing.B) {
+	benchmarks := []struct {
+		name      string
+		budget    time.Duration
+		longLived int
+	}{
+		{"single sample", 0, 0},
+		{"adaptive", DefaultSampleBudget, 0},
+		{"adaptive mixed ttl", DefaultSampleBudget, 1000},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			var m runtime.MemStats
+			for n := 0; n < b.N; n++ {
+				lc := NewLRU(WithTTL(time.Millisecond*10), WithSampleBudget(bm.budget))
+				for i := 0; i < bm.longLived; i++ {
+					lc.(TTLSetter).SetWithTTL(key(i), value(i), time.Hour*1)
+				}
+				for i := bm.longLived; i < 100000; i++ {
+					lc.Set(key(i), value(i))
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&m)
+				before := m.HeapInuse
+
+				time.Sleep(time.Second * 1)
+				runtime.GC()
+				runtime.ReadMemStats(&m)
+
+				s := lc.(StatsReporter).Stats()
+				b.Logf("items left: %d, heap in use: %d KB -> %d KB, cycles: %d, rounds: %d",
+					s.Items, before>>10, m.HeapInuse>>10, s.SampleCycles, s.SampleRounds)
+				lc.Close()
+			}
+		})
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	lc := NewLRUCache(100, time.Hour*1)
+	defer lc.Close()
+	c := lc.(*lruCache)
+
+	c.SetWithTTL(key(0), value(0), time.Millisecond*1)
+	c.SetWithTTL(key(1), value(1), 0)
+
+	time.Sleep(time.Millisecond * 1)
+
+	val, err := c.Get(key(0))
+	if val != "" || err != ErrKeyNotFound {
+		t.Fatalf("expected key to be deleted after its own expiry")
+	}
+
+	val, err = c.Get(key(1))
+	if val != value(1) || err != nil {
+		t.Fatalf("expected key without a ttl to use the default expiry")
+	}
+}
+
+func TestSetExistingKey(t *testing.T) {
+	lc := NewLRUCache(2, time.Hour*1)
+	defer lc.Close()
+	c := lc.(*lruCache)
+
+	c.Set(key(0), value(0))
+	c.Set(key(0), value(1))
+	c.Set(key(1), value(1))
+
+	val, err := c.Get(key(0))
+	if val != value(1) || err != nil {
+		t.Fatalf("expected the value for the key to be replaced")
+	}
+
+	if c.list.Len() != 2 || len(c.lookupTable) != 2 {
+		t.Fatalf("expected replaced entries to be removed from the list")
+	}
+}
+
+func TestSizedCache_EvictOldest(t *testing.T) {
+	// each entry uses 10 bytes, "key%d" and "value%d"
+	// for single digit keys.
+	lc := NewSizedLRUCache(30, 20, time.Hour*1)
+	defer lc.Close()
+	c := lc.(*lruCache)
+	c.timeWindow = 0
+
+	for i := 0; i < 3; i++ {
+		c.Set(key(i), value(i))
+	}
+	if c.bytes != 30 {
+		t.Fatalf("expected the cache to track the bytes of its entries, received: %d", c.bytes)
+	}
+
+	// key0 is promoted, so key1 is the oldest
+	// entry and is evicted to make room.
+	c.Get(key(0))
+	c.Set(key(3), value(3))
+
+	if _, err := c.Get(key(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected oldest value to be evicted")
+	}
+	if val, err := c.Get(key(0)); val != value(0) || err != nil {
+		t.Fatalf("expected promoted value to be found")
+	}
+
+	// a larger entry evicts as many entries as needed.
+	c.Set("large", "0123456789012")
+	if c.bytes > 30 || len(c.lookupTable) != 2 {
+		t.Fatalf("expected entries to be evicted until the new entry fits, bytes: %d", c.bytes)
+	}
+}
+
+func TestSizedCache_RejectLargeItem(t *testing.T) {
+	lc := NewSizedLRUCache(100, 20, time.Hour*1)
+	defer lc.Close()
+	c := lc.(*lruCache)
+
+	c.Set(key(0), value(0))
+	c.Set(key(1), value(1))
+
+	// replacing an entry with a value that is too
+	// large drops the existing entry as well.
+	c.Set(key(1), "a value which is much larger than the max item size")
+	if _, err := c.Get(key(1)); err != ErrKeyNotFound {
+		t.Fatalf("expected entry larger than the max item size to be rejected")
+	}
+	if val, err := c.Get(key(0)); val != value(0) || err != nil || c.bytes != 10 {
+		t.Fatalf("expected other entries to be unaffected by a rejected entry")
+	}
+}
+
+func TestDeleteFlush(t *testing.T) {
+	lc := NewSizedLRUCache(1000, 100, time.Hour*1)
+	defer lc.Close()
+	c := lc.(*lruCache)
+	c.timeWindow = 0
+
+	for i := 0; i < 10; i++ {
+		c.Set(key(i), value(i))
+	}
+
+	if !c.Delete(key(0)) || c.bytes != 90 || c.list.Len() != 9 {
+		t.Fatalf("expected deleted key to be removed from the list")
+	}
+
+	// an item looked up before the flush
+	// must not be moved back into the list.
+	it, _, _, _ := c.searchItem(key(1))
+	c.Flush()
+	c.moveItemFront(it)
+
+	if c.bytes != 0 || c.list.Len() != 0 || len(c.lookupTable) != 0 {
+		t.Fatalf("expected flush to remove all the keys")
+	}
+}
+
+func TestStats(t *testing.T) {
+	lc := NewSizedLRUCache(30, 10, time.Hour*1)
+	defer lc.Close()
+	c := lc.(*lruCache)
+
+	for i := 0; i < 4; i++ {
+		c.Set(key(i), value(i))
+	}
+	c.Set("large", "a value which is too large")
+	c.SetWithTTL(key(4), value(4), time.Millisecond*1)
+	c.SetWithTTL(key(5), value(5), time.Millisecond*1)
+
+	time.Sleep(time.Millisecond * 1)
+
+	c.Get(key(3))
+	c.Get(key(4))
+	c.Get(key(6))
+	c.removeStaleData(map[string]struct{}{key(5): {}})
+
+	// the background cleanup might have removed
+	// the expired keys before they were looked up.
+	s := c.Stats()
+	if s.Expirations+s.LazyExpirations != 2 {
+		t.Fatalf("expected expired keys to be counted, stats: %+v", s)
+	}
+
+	s.Expirations, s.LazyExpirations = 0, 0
+
+	// the background cleanup runs
+	// independently of the test.
+	s.SampleCycles, s.SampleRounds, s.SampleChecked = 0, 0, 0
+	s.LastCycleChecked, s.LastCycleExpired = 0, 0
+	expected := Stats{
+		Hits:       1,
+		Misses:     2,
+		Evictions:  3,
+		Rejections: 1,
+		Items:      1,
+		Bytes:      10,
+	}
+	if s != expected {
+		t.Fatalf("unexpected stats, expected: %+v, received: %+v", expected, s)
+	}
+}
+
+// waitForGoroutines waits for the number of running
+// goroutines to drop to n, and reports if it did.
+func waitForGoroutines(n int) bool {
+	for i := 0; i < 100; i++ {
+		if runtime.NumGoroutine() <= n {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return false
+}
+
+func TestClose_NoGoroutineLeak(t *testing.T) {
+	caches := make([]Cacher, 10)
+	for i := range caches {
+		caches[i] = NewLRUCache(100, time.Hour*1)
+	}
+	sharded := NewShardedCache(4, func() Cacher {
+		return NewSizedLRUCache(100, 10, time.Hour*1)
+	})
+	running := runtime.NumGoroutine()
+
+	for _, c := range caches {
+		c.Close()
+	}
+	sharded.Close()
+
+	// each cache runs a background cleanup.
+	if !waitForGoroutines(running - 14) {
+		t.Fatalf("expected close to stop the background cleanup, goroutines before: %d, after: %d", running, runtime.NumGoroutine())
+	}
+}
+
+func TestClose(t *testing.T) {
+	lc := NewLRUCache(100, time.Hour*1)
+	c := lc.(*lruCache)
+	c.timeWindow = 0
+
+	c.Set(key(0), value(0))
+	it, _, _, _ := c.searchItem(key(0))
+
+	if c.Close() != nil || c.Close() != nil {
+		t.Fatalf("expected close to be safe to call more than once")
+	}
+	if c.lookupTable != nil || c.list != nil {
+		t.Fatalf("expected close to release the entries")
+	}
+
+	// an item looked up before the close
+	// must not be moved back into the list.
+	c.moveItemFront(it)
+
+	if val, err := c.Get(key(0)); val != "" || err != ErrClosed {
+		t.Fatalf("expected get after close to return an error")
+	}
+	if err := c.TrySet(key(1), value(1), 0); err != ErrClosed {
+		t.Fatalf("expected a checked set after close to return an error")
+	}
+	c.Set(key(1), value(1))
+	if _, err := c.Get(key(1)); err != ErrClosed || c.Delete(key(0)) {
+		t.Fatalf("expected set after close to be a no-op")
+	}
+	c.Flush()
+}
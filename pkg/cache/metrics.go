@@ -0,0 +1,44 @@
+package cache
+
+import "github.com/vikramsk/rediproxy/pkg/metrics"
+
+// NewCollectors initializes the metrics for the
+// cache, which are read from its stats when they're
+// collected. The metric names are prefixed with
+// rediproxy_cache.
+func NewCollectors(sr StatsReporter) []metrics.Collector {
+	stat := func(fn func(Stats) float64) func() float64 {
+		return func() float64 {
+			return fn(sr.Stats())
+		}
+	}
+
+	return []metrics.Collector{
+		metrics.NewCounterFunc("rediproxy_cache_hits_total", "Number of cache lookups which found a valid key.",
+			stat(func(s Stats) float64 { return float64(s.Hits) })),
+		metrics.NewCounterFunc("rediproxy_cache_misses_total", "Number of cache lookups which didn't find a valid key.",
+			stat(func(s Stats) float64 { return float64(s.Misses) })),
+		metrics.NewCounterFunc("rediproxy_cache_evictions_total", "Number of keys removed to make room for new keys.",
+			stat(func(s Stats) float64 { return float64(s.Evictions) })),
+		metrics.NewCounterFunc("rediproxy_cache_expirations_total", "Number of expired keys removed by the background cleanup.",
+			stat(func(s Stats) float64 { return float64(s.Expirations) })),
+		metrics.NewCounterFunc("rediproxy_cache_lazy_expirations_total", "Number of expired keys removed when they were looked up.",
+			stat(func(s Stats) float64 { return float64(s.LazyExpirations) })),
+		metrics.NewCounterFunc("rediproxy_cache_rejections_total", "Number of keys which were too large to be added.",
+			stat(func(s Stats) float64 { return float64(s.Rejections) })),
+		metrics.NewCounterFunc("rediproxy_cache_sample_cycles_total", "Number of runs of the background cleanup.",
+			stat(func(s Stats) float64 { return float64(s.SampleCycles) })),
+		metrics.NewCounterFunc("rediproxy_cache_sample_rounds_total", "Number of samples of keys checked by the background cleanup.",
+			stat(func(s Stats) float64 { return float64(s.SampleRounds) })),
+		metrics.NewCounterFunc("rediproxy_cache_sample_checked_total", "Number of keys checked by the background cleanup.",
+			stat(func(s Stats) float64 { return float64(s.SampleChecked) })),
+		metrics.NewGaugeFunc("rediproxy_cache_last_cycle_checked", "Number of keys checked by the last run of the background cleanup.",
+			stat(func(s Stats) float64 { return float64(s.LastCycleChecked) })),
+		metrics.NewGaugeFunc("rediproxy_cache_last_cycle_expired", "Number of keys expired by the last run of the background cleanup.",
+			stat(func(s Stats) float64 { return float64(s.LastCycleExpired) })),
+		metrics.NewGaugeFunc("rediproxy_cache_items", "Number of keys in the cache.",
+			stat(func(s Stats) float64 { return float64(s.Items) })),
+		metrics.NewGaugeFunc("rediproxy_cache_bytes", "Number of bytes used by the entries in the cache.",
+			stat(func(s Stats) float64 { return float64(s.Bytes) })),
+	}
+}
@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCollectors(t *testing.T) {
+	c := NewLRUCache(100, time.Hour*1)
+	defer c.Close()
+	c.Set(key(0), value(0))
+	c.Get(key(0))
+	c.Get(key(1))
+
+	var buf bytes.Buffer
+	for _, col := range NewCollectors(c.(StatsReporter)) {
+		col.Collect(&buf)
+	}
+
+	for _, sample := range []string{
+		"rediproxy_cache_hits_total 1\n",
+		"rediproxy_cache_misses_total 1\n",
+		"rediproxy_cache_items 1\n",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(sample)) {
+			t.Fatalf("expected the metrics to contain: %q, received:\n%s", sample, buf.String())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package cache_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vikramsk/rediproxy/pkg/cache"
+	"github.com/vikramsk/rediproxy/pkg/internal/mocks"
+)
+
+func TestNewLRU_Unlimited(t *testing.T) {
+	c := cache.NewLRU(cache.WithTTL(time.Hour * 1))
+	defer c.Close()
+
+	for i := 0; i < 1000; i++ {
+		c.Set(cache.Key(i), cache.Value(i))
+	}
+	if s := c.(cache.StatsReporter).Stats(); s.Items != 1000 || s.Evictions != 0 {
+		t.Fatalf("expected no limits on the cache, stats: %+v", s)
+	}
+}
+
+func TestNewLRU_NoOptions(t *testing.T) {
+	c := cache.NewLRU()
+	defer c.Close()
+
+	// keys never expire without a ttl.
+	c.Set(cache.Key(0), cache.Value(0))
+	if val, err := c.Get(cache.Key(0)); val != cache.Value(0) || err != nil {
+		t.Fatalf("expected value to be found without a ttl, received: %v", err)
+	}
+	if e, _ := c.(cache.EntryGetter).GetEntry(cache.Key(0)); !e.Expiry.IsZero() {
+		t.Fatalf("expected no expiry without a ttl, received: %v", e.Expiry)
+	}
+}
+
+func TestNewLRU_Sampler(t *testing.T) {
+	clock := mocks.NewClock(time.Now())
+	c := cache.NewLRU(
+		cache.WithTTL(time.Second*1),
+		cache.WithClock(clock),
+		cache.WithSampleSize(5),
+		cache.WithSampleInterval(time.Second*10),
+		cache.WithSampleBudget(0),
+	)
+	defer c.Close()
+	sr := c.(cache.StatsReporter)
+
+	clock.BlockUntil(1)
+	for i := 0; i < 20; i++ {
+		c.Set(cache.Key(i), cache.Value(i))
+	}
+
+	// the keys have expired, but the
+	// cleanup isn't due yet.
+	clock.Advance(time.Second * 2)
+	if s := sr.Stats(); s.Items != 20 {
+		t.Fatalf("expected the cleanup to wait for the interval, received: %d keys", s.Items)
+	}
+
+	for _, expected := range []int{15, 10} {
+		clock.Advance(time.Second * 10)
+		clock.BlockUntil(1)
+
+		if s := sr.Stats(); s.Items != expected {
+			t.Fatalf("expected %d keys after the cleanup, received: %d", expected, s.Items)
+		}
+	}
+}
+
+func TestNewLRU_RepeatThreshold(t *testing.T) {
+	clock := mocks.NewClock(time.Now())
+	c := cache.NewLRU(
+		cache.WithTTL(time.Second*1),
+		cache.WithClock(clock),
+		cache.WithSampleSize(5),
+		cache.WithSampleInterval(time.Second*10),
+		cache.WithSampleBudget(time.Hour*1),
+		cache.WithRepeatThreshold(1),
+	)
+	defer c.Close()
+	sr := c.(cache.StatsReporter)
+
+	clock.BlockUntil(1)
+	for i := 0; i < 20; i++ {
+		c.Set(cache.Key(i), cache.Value(i))
+	}
+
+	// with a threshold of 1, a cycle never samples
+	// again, despite every sampled key being expired.
+	clock.Advance(time.Second * 10)
+	clock.BlockUntil(1)
+	if s := sr.Stats(); s.Items != 15 || s.SampleRounds != s.SampleCycles {
+		t.Fatalf("expected a single sample per cycle, stats: %+v", s)
+	}
+}
+
+func TestNewLRU_PromotionWindow(t *testing.T) {
+	clock := mocks.NewClock(time.Now())
+	c := cache.NewLRU(
+		cache.WithCapacity(2),
+		cache.WithTTL(time.Hour*1),
+		cache.WithClock(clock),
+		cache.WithPromotionWindow(0),
+	)
+	defer c.Close()
+
+	c.Set("a", "value")
+	c.Set("b", "value")
+
+	// without a window, a lookup always
+	// moves the key to the front.
+	clock.Advance(time.Nanosecond * 1)
+	c.Get("a")
+	c.Set("c", "value")
+
+	if _, err := c.Get("a"); err != nil {
+		t.Fatalf("expected key to be promoted on lookup")
+	}
+	if _, err := c.Get("b"); err != cache.ErrKeyNotFound {
+		t.Fatalf("expected least recently used key to be evicted")
+	}
+}
+
+func TestNewLRU_EvictionCallback(t *testing.T) {
+	var evicted []string
+	var c cache.Cacher
+	c = cache.NewLRU(
+		cache.WithCapacity(2),
+		cache.WithTTL(time.Hour*1),
+		cache.WithEvictionCallback(func(key, value string, reason cache.EvictReason) {
+			// the callback can use the cache.
+			c.Get(key)
+			evicted = append(evicted, key+"="+value+":"+reason.String())
+		}),
+	)
+	defer c.Close()
+
+	for i := 0; i < 4; i++ {
+		c.Set(cache.Key(i), cache.Value(i))
+	}
+	c.Delete(cache.Key(3))
+
+	expected := []string{"key0=value0:capacity", "key1=value1:capacity", "key3=value3:deleted"}
+	if !reflect.DeepEqual(evicted, expected) {
+		t.Fatalf("unexpected evictions, expected: %v, received: %v", expected, evicted)
+	}
+}
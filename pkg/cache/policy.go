@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// Policy defines the eviction policy
+// used by a cache when it's at capacity.
+type Policy string
+
+// The eviction policies supported by New.
+const (
+	// PolicyLRU evicts the least recently used key.
+	PolicyLRU Policy = "lru"
+
+	// PolicyLFU evicts the least frequently used key.
+	PolicyLFU Policy = "lfu"
+
+	// PolicyARC balances between recency and frequency
+	// using the adaptive replacement cache algorithm.
+	PolicyARC Policy = "arc"
+
+	// PolicyTinyLFU admits keys into the cache based on
+	// their estimated frequency, using the W-TinyLFU
+	// algorithm.
+	PolicyTinyLFU Policy = "tinylfu"
+)
+
+// ErrUnknownPolicy is the error returned when
+// the eviction policy isn't supported.
+var ErrUnknownPolicy = errors.New("cache: unknown eviction policy")
+
+// Valid checks if the policy is supported by New.
+func (p Policy) Valid() bool {
+	switch p {
+	case PolicyLRU, PolicyLFU, PolicyARC, PolicyTinyLFU:
+		return true
+	}
+	return false
+}
+
+// PolicyOption configures the caches
+// created by New, for every policy.
+type PolicyOption func(*policyOptions)
+
+// policyOptions holds the options
+// shared by the eviction policies.
+type policyOptions struct {
+	clock Clock
+}
+
+// WithPolicyClock sets the source of time for the
+// cache. It defaults to the time package.
+func WithPolicyClock(c Clock) PolicyOption {
+	return func(o *policyOptions) {
+		o.clock = c
+	}
+}
+
+// newPolicyOptions applies the options
+// on top of the defaults.
+func newPolicyOptions(opts []PolicyOption) policyOptions {
+	o := policyOptions{clock: realClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// New is used to initialize a cache with the given
+// eviction policy. It accepts the policy, the capacity
+// of the cache, the time to live for the objects in the
+// cache, and the options. Only the LRU cache removes
+// expired keys in the background. The other policies
+// remove them when they're looked up, or evicted.
+func New(p Policy, c int, t time.Duration, opts ...PolicyOption) (Cacher, error) {
+	switch p {
+	case PolicyLRU:
+		return NewLRUCache(c, t, WithClock(newPolicyOptions(opts).clock)), nil
+	case PolicyLFU:
+		return NewLFUCache(c, t, opts...), nil
+	case PolicyARC:
+		return NewARCCache(c, t, opts...), nil
+	case PolicyTinyLFU:
+		return NewTinyLFUCache(c, t, opts...), nil
+	default:
+		return nil, ErrUnknownPolicy
+	}
+}
+
+// entry represents a single cache entry for
+// the caches which don't run a background
+// cleanup. expired entries are removed
+// lazily when they are looked up.
+type entry struct {
+	key   string
+	value string
+
+	// expiry refers to the time
+	// at which the key is invalid.
+	// times are stored in UTC. it's
+	// zero if the key never expires.
+	expiry time.Time
+}
+
+// newEntry initializes an entry with the given
+// ttl, falling back to the default ttl if the
+// ttl is <= 0. The entry never expires if both
+// are <= 0. now is the current time.
+func newEntry(k, v string, ttl, defaultTTL time.Duration, now time.Time) *entry {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	e := &entry{
+		key:   k,
+		value: v,
+	}
+	if ttl > 0 {
+		e.expiry = now.UTC().Add(ttl)
+	}
+	return e
+}
+
+// expired checks if the entry is invalid at the given time.
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiry.IsZero() && e.expiry.Sub(now) < 0
+}
@@ -0,0 +1,278 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+var policies = []Policy{PolicyLRU, PolicyLFU, PolicyARC, PolicyTinyLFU}
+
+func TestNew(t *testing.T) {
+	for _, p := range policies {
+		c, err := New(p, 100, time.Hour*1)
+		if c == nil || err != nil {
+			t.Fatalf("expected cache to be created for policy: %s", p)
+		}
+		defer c.Close()
+
+		c.Set(key(0), value(0))
+		if val, err := c.Get(key(0)); val != value(0) || err != nil {
+			t.Fatalf("expected value to be found for policy: %s", p)
+		}
+		if _, err := c.Get(key(1)); err != ErrKeyNotFound {
+			t.Fatalf("expected missing key to return key not found for policy: %s", p)
+		}
+	}
+
+	if Policy("unknown").Valid() {
+		t.Fatalf("expected an unknown policy to be invalid")
+	}
+	if _, err := New("unknown", 100, time.Hour*1); err != ErrUnknownPolicy {
+		t.Fatalf("expected an error for an unknown policy")
+	}
+}
+
+func TestPolicyKeyExpiry(t *testing.T) {
+	for _, p := range policies {
+		c, _ := New(p, 100, time.Hour*1)
+		defer c.Close()
+		c.(TTLSetter).SetWithTTL(key(0), value(0), time.Millisecond*1)
+
+		time.Sleep(time.Millisecond * 1)
+
+		if val, err := c.Get(key(0)); val != "" || err != ErrKeyNotFound {
+			t.Fatalf("expected key to be deleted after expiry for policy: %s", p)
+		}
+	}
+}
+
+func TestPolicyNoTTL(t *testing.T) {
+	for _, p := range policies {
+		c, _ := New(p, 100, 0)
+		defer c.Close()
+		c.Set(key(0), value(0))
+
+		if val, err := c.Get(key(0)); val != value(0) || err != nil {
+			t.Fatalf("expected key without a ttl not to expire for policy: %s", p)
+		}
+	}
+}
+
+func TestPolicyCapacity(t *testing.T) {
+	for _, p := range policies {
+		c, _ := New(p, 100, time.Hour*1)
+		defer c.Close()
+		for i := 0; i < 1000; i++ {
+			c.Get(key(i))
+			c.Set(key(i), value(i))
+		}
+
+		found := 0
+		for i := 0; i < 1000; i++ {
+			if _, err := c.Get(key(i)); err == nil {
+				found++
+			}
+		}
+		if found == 0 || found > 100 {
+			t.Fatalf("expected the cache to hold at most its capacity for policy: %s, found: %d", p, found)
+		}
+	}
+}
+
+// zipfTrace returns n keys drawn from a zipfian
+// distribution over the given number of keys.
+func zipfTrace(n, keys int) []string {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.01, 1, uint64(keys-1))
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = key(int(z.Uint64()))
+	}
+	return trace
+}
+
+// scanTrace returns a zipfian trace in which every
+// other block of accesses is replaced by a scan over
+// keys which are never accessed again.
+func scanTrace(n, keys, block int) []string {
+	trace := zipfTrace(n, keys)
+	cold := keys
+	for i := block; i < n; i += 2 * block {
+		for j := i; j < i+block && j < n; j++ {
+			trace[j] = key(cold)
+			cold++
+		}
+	}
+	return trace
+}
+
+// benchmarkHitRatio replays the trace against the cache
+// for each policy, setting the key on every miss.
+func benchmarkHitRatio(b *testing.B, trace []string) {
+	for _, p := range policies {
+		b.Run(string(p), func(b *testing.B) {
+			c, _ := New(p, 1000, time.Hour*1)
+			defer c.Close()
+
+			b.ResetTimer()
+
+			var hit, miss int
+			for i := 0; i < b.N; i++ {
+				k := trace[i%len(trace)]
+				if _, err := c.Get(k); err == nil {
+					hit++
+				} else {
+					miss++
+					c.Set(k, k)
+				}
+			}
+			b.Logf("hit: %d miss: %d ratio: %f", hit, miss, float64(hit)/float64(hit+miss))
+		})
+	}
+}
+
+func BenchmarkHitRatioZipf(b *testing.B) {
+	benchmarkHitRatio(b, zipfTrace(1<<18, 100000))
+}
+
+func BenchmarkHitRatioScan(b *testing.B) {
+	benchmarkHitRatio(b, scanTrace(1<<18, 100000, 5000))
+}
+
+func ExampleNew() {
+	c, err := New(PolicyTinyLFU, 1000, time.Hour*1)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	c.Set("key", "value")
+	fmt.Println(c.Get("key"))
+	// Output: value <nil>
+}
+
+func TestPolicyDeleteFlush(t *testing.T) {
+	for _, p := range policies {
+		c, _ := New(p, 100, time.Hour*1)
+		defer c.Close()
+		for i := 0; i < 10; i++ {
+			c.Set(key(i), value(i))
+		}
+
+		if !c.Delete(key(0)) || c.Delete(key(0)) {
+			t.Fatalf("expected delete to report if the key was present for policy: %s", p)
+		}
+		if _, err := c.Get(key(0)); err != ErrKeyNotFound {
+			t.Fatalf("expected deleted key to be removed for policy: %s", p)
+		}
+
+		c.Flush()
+		for i := 0; i < 10; i++ {
+			if _, err := c.Get(key(i)); err != ErrKeyNotFound {
+				t.Fatalf("expected flush to remove all the keys for policy: %s", p)
+			}
+		}
+
+		// the cache should be usable after a flush.
+		c.Set(key(0), value(0))
+		if val, err := c.Get(key(0)); val != value(0) || err != nil {
+			t.Fatalf("expected value to be found after a flush for policy: %s", p)
+		}
+	}
+}
+
+func TestPolicyStats(t *testing.T) {
+	for _, p := range policies {
+		c, _ := New(p, 10, time.Hour*1)
+		defer c.Close()
+		for i := 0; i < 20; i++ {
+			c.Get(key(i))
+			c.Set(key(i), value(i))
+			c.Get(key(i))
+		}
+		c.(TTLSetter).SetWithTTL(key(0), value(0), time.Millisecond*1)
+		time.Sleep(time.Millisecond * 1)
+		c.Get(key(0))
+
+		s := c.(StatsReporter).Stats()
+		if s.Hits+s.Misses != 41 || s.Hits == 0 {
+			t.Fatalf("expected every lookup to be counted for policy: %s, stats: %+v", p, s)
+		}
+		if s.Evictions == 0 || s.Items == 0 || s.Items > 10 {
+			t.Fatalf("expected evictions at capacity to be counted for policy: %s, stats: %+v", p, s)
+		}
+
+		// the background cleanup might have removed
+		// the expired key before it was looked up.
+		if s.Expirations+s.LazyExpirations != 1 {
+			t.Fatalf("expected expired keys to be counted for policy: %s, stats: %+v", p, s)
+		}
+	}
+}
+
+func TestPolicyOnEvict(t *testing.T) {
+	for _, p := range policies {
+		c, _ := New(p, 10, time.Hour*1)
+		// the lru cache reports the keys removed
+		// by its background cleanup concurrently.
+		var mu sync.Mutex
+		var values int
+		reasons := make(map[EvictReason]uint64)
+		c.(EvictNotifier).OnEvict(func(key, value string, reason EvictReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			if value != "" {
+				values++
+			}
+			reasons[reason]++
+		})
+
+		for i := 0; i < 20; i++ {
+			c.Set(key(i), value(i))
+			c.Get(key(i))
+		}
+		c.Set(key(20), value(20))
+		c.Delete(key(20))
+		c.(TTLSetter).SetWithTTL(key(21), value(21), time.Millisecond*1)
+		time.Sleep(time.Millisecond * 1)
+		c.Get(key(21))
+		c.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+		s := c.(StatsReporter).Stats()
+		if reasons[EvictCapacity] != s.Evictions || s.Evictions == 0 {
+			t.Fatalf("expected evictions at capacity to be reported for policy: %s, reasons: %v", p, reasons)
+		}
+		if reasons[EvictDeleted] != 1 || reasons[EvictExpired] != 1 {
+			t.Fatalf("expected deleted and expired keys to be reported for policy: %s, reasons: %v", p, reasons)
+		}
+		if values != int(s.Evictions)+2 {
+			t.Fatalf("expected the values of the keys to be reported for policy: %s", p)
+		}
+	}
+}
+
+func TestPolicyClose(t *testing.T) {
+	for _, p := range policies {
+		c, _ := New(p, 100, time.Hour*1)
+		c.Set(key(0), value(0))
+
+		if c.Close() != nil || c.Close() != nil {
+			t.Fatalf("expected close to be safe to call more than once for policy: %s", p)
+		}
+		if _, err := c.Get(key(0)); err != ErrClosed {
+			t.Fatalf("expected get after close to return an error for policy: %s", p)
+		}
+		if err := c.(CheckedSetter).TrySet(key(1), value(1), 0); err != ErrClosed {
+			t.Fatalf("expected a checked set after close to return an error for policy: %s", p)
+		}
+		c.Set(key(1), value(1))
+		c.Flush()
+		if c.Delete(key(1)) {
+			t.Fatalf("expected set after close to be a no-op for policy: %s", p)
+		}
+	}
+}
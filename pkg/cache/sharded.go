@@ -0,0 +1,133 @@
+package cache
+
+import "time"
+
+// shardedCache is a cache which hashes keys
+// across independent caches, so that each of
+// them is guarded by its own lock.
+type shardedCache struct {
+	shards []Cacher
+}
+
+// ensure that the sharded cache supports
+// a ttl per key.
+var _ = TTLSetter(&shardedCache{})
+var _ = CheckedSetter(&shardedCache{})
+var _ = StatsReporter(&shardedCache{})
+var _ = EvictNotifier(&shardedCache{})
+var _ = EntryGetter(&shardedCache{})
+
+// NewShardedCache is used to initialize a cache which
+// hashes keys across n independent shards. It accepts
+// the number of shards and the func used to initialize
+// each shard, e.g. an LRU cache with 1/n of the capacity.
+func NewShardedCache(n int, newShard func() Cacher) Cacher {
+	if n < 1 {
+		n = 1
+	}
+	sc := &shardedCache{
+		shards: make([]Cacher, n),
+	}
+	for i := range sc.shards {
+		sc.shards[i] = newShard()
+	}
+	return sc
+}
+
+// Get looks up the key in the shard for the key.
+func (sc *shardedCache) Get(key string) (string, error) {
+	return sc.shard(key).Get(key)
+}
+
+// GetEntry looks up the key in the shard for the key,
+// and returns its value along with its expiry. The
+// expiry is zero if the shard doesn't report it.
+func (sc *shardedCache) GetEntry(key string) (Entry, error) {
+	s := sc.shard(key)
+	if eg, ok := s.(EntryGetter); ok {
+		return eg.GetEntry(key)
+	}
+	val, err := s.Get(key)
+	return Entry{Value: val}, err
+}
+
+// Set adds the key value pair to the shard for the key.
+func (sc *shardedCache) Set(k, v string) {
+	sc.shard(k).Set(k, v)
+}
+
+// SetWithTTL adds the key value pair to the shard for
+// the key with the given time to live. It falls back to
+// Set if the shard doesn't support a ttl per key.
+func (sc *shardedCache) SetWithTTL(k, v string, ttl time.Duration) {
+	s := sc.shard(k)
+	if ts, ok := s.(TTLSetter); ok {
+		ts.SetWithTTL(k, v, ttl)
+		return
+	}
+	s.Set(k, v)
+}
+
+// TrySet is the counterpart of SetWithTTL, which returns
+// the error of the shard for the key. The error is nil if
+// the shard doesn't report it.
+func (sc *shardedCache) TrySet(k, v string, ttl time.Duration) error {
+	s := sc.shard(k)
+	if cs, ok := s.(CheckedSetter); ok {
+		return cs.TrySet(k, v, ttl)
+	}
+	sc.SetWithTTL(k, v, ttl)
+	return nil
+}
+
+// Delete removes the key from the shard for the key.
+func (sc *shardedCache) Delete(key string) bool {
+	return sc.shard(key).Delete(key)
+}
+
+// Flush removes all the keys from every shard.
+func (sc *shardedCache) Flush() {
+	for _, s := range sc.shards {
+		s.Flush()
+	}
+}
+
+// Stats returns the sum of the stats for the
+// shards which report them.
+func (sc *shardedCache) Stats() Stats {
+	var s Stats
+	for _, c := range sc.shards {
+		if sr, ok := c.(StatsReporter); ok {
+			s = s.add(sr.Stats())
+		}
+	}
+	return s
+}
+
+// OnEvict sets the func called with the keys
+// leaving the shards which report them.
+func (sc *shardedCache) OnEvict(fn EvictFunc) {
+	for _, c := range sc.shards {
+		if en, ok := c.(EvictNotifier); ok {
+			en.OnEvict(fn)
+		}
+	}
+}
+
+// Close closes every shard. It returns
+// the first error encountered.
+func (sc *shardedCache) Close() error {
+	var err error
+	for _, s := range sc.shards {
+		if cerr := s.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// shard returns the shard for the key,
+// using the fnv-1a hash of the key.
+func (sc *shardedCache) shard(key string) Cacher {
+	return sc.shards[hashKey(key)%uint64(len(sc.shards))]
+}
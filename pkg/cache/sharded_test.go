@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestShardedCache(t *testing.T) {
+	lc := NewShardedCache(8, func() Cacher {
+		return NewLRUCache(100, time.Hour*1)
+	})
+	defer lc.Close()
+	c := lc.(*shardedCache)
+
+	for i := 0; i < 100; i++ {
+		c.Set(key(i), value(i))
+	}
+	c.SetWithTTL(key(100), value(100), time.Millisecond*1)
+
+	for i := 0; i < 100; i++ {
+		val, err := c.Get(key(i))
+		if val != value(i) || err != nil {
+			t.Fatalf("expected value to be found for key: %s", key(i))
+		}
+	}
+
+	time.Sleep(time.Millisecond * 1)
+	if _, err := c.Get(key(100)); err != ErrKeyNotFound {
+		t.Fatalf("expected key to be deleted after its own expiry")
+	}
+
+	// the keys should be spread across the shards.
+	for i, s := range c.shards {
+		if len(s.(*lruCache).lookupTable) == 0 {
+			t.Fatalf("expected keys to be hashed to shard: %d", i)
+		}
+	}
+}
+
+// benchmarkParallel runs an even mix of Get and Set
+// calls against the cache from parallel goroutines.
+func benchmarkParallel(b *testing.B, c Cacher) {
+	defer c.Close()
+	trace := make([]string, 1<<16)
+	for i := range trace {
+		trace[i] = key(rand.Int() % 32768)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Int()
+		for pb.Next() {
+			k := trace[i%len(trace)]
+			if i%2 == 0 {
+				c.Set(k, k)
+			} else {
+				c.Get(k)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkLRUParallel(b *testing.B) {
+	benchmarkParallel(b, NewLRUCache(8192, time.Hour*1))
+}
+
+func BenchmarkShardedLRUParallel(b *testing.B) {
+	for _, n := range []int{4, 16, 64} {
+		n := n
+		b.Run(fmt.Sprintf("shards-%d", n), func(b *testing.B) {
+			benchmarkParallel(b, NewShardedCache(n, func() Cacher {
+				return NewLRUCache(8192/n, time.Hour*1)
+			}))
+		})
+	}
+}
+
+func TestShardedCache_DeleteFlush(t *testing.T) {
+	c := NewShardedCache(4, func() Cacher {
+		return NewLRUCache(100, time.Hour*1)
+	})
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		c.Set(key(i), value(i))
+	}
+
+	if !c.Delete(key(0)) {
+		t.Fatalf("expected key to be deleted from its shard")
+	}
+
+	c.Flush()
+	for i := 0; i < 10; i++ {
+		if _, err := c.Get(key(i)); err != ErrKeyNotFound {
+			t.Fatalf("expected flush to remove the keys from every shard")
+		}
+	}
+}
+
+func TestShardedCache_Stats(t *testing.T) {
+	c := NewShardedCache(4, func() Cacher {
+		return NewLRUCache(100, time.Hour*1)
+	})
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		c.Set(key(i), value(i))
+		c.Get(key(i))
+	}
+
+	s := c.(StatsReporter).Stats()
+	if s.Items != 10 || s.Hits != 10 {
+		t.Fatalf("expected the stats to be summed across shards, stats: %+v", s)
+	}
+}
@@ -0,0 +1,114 @@
+package cache
+
+// sketchDepth is the number of rows in the
+// count-min sketch. each key is counted
+// once per row.
+const sketchDepth = 4
+
+// sketchMaxCount is the max. value of a
+// counter. counters saturate at this value.
+const sketchMaxCount = 15
+
+// sketchSeeds are used to derive an
+// independent hash for each row.
+var sketchSeeds = [sketchDepth]uint64{
+	0xc3a5c85c97cb3127,
+	0xb492b66fbe98f273,
+	0x9ae16a3b2f90404f,
+	0xcbf29ce484222325,
+}
+
+// countMinSketch estimates the frequency of keys in
+// a fixed amount of memory, as described in the
+// TinyLFU paper by Einziger, Friedman and Manes.
+// The counters are halved once the number of recorded
+// accesses hits the sample size, so that the estimates
+// favor recent history.
+type countMinSketch struct {
+	rows [sketchDepth][]uint8
+	mask uint64
+
+	// additions is the number of
+	// accesses since the last reset.
+	additions int
+
+	// sampleSize is the number of
+	// accesses between resets.
+	sampleSize int
+}
+
+// newCountMinSketch initializes a sketch
+// sized for the given number of keys.
+func newCountMinSketch(keys int) *countMinSketch {
+	width := 16
+	for width < keys {
+		width <<= 1
+	}
+	s := &countMinSketch{
+		mask:       uint64(width - 1),
+		sampleSize: 10 * keys,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// increment records an access for the key.
+func (s *countMinSketch) increment(key string) {
+	h := hashKey(key)
+	for i := range s.rows {
+		idx := s.index(h, i)
+		if s.rows[i][idx] < sketchMaxCount {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// estimate returns the estimated
+// frequency of the key.
+func (s *countMinSketch) estimate(key string) uint8 {
+	h := hashKey(key)
+	f := uint8(sketchMaxCount)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < f {
+			f = c
+		}
+	}
+	return f
+}
+
+// reset halves all the counters.
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// index returns the position of
+// the hash in the given row.
+func (s *countMinSketch) index(h uint64, row int) uint64 {
+	h ^= sketchSeeds[row]
+	h *= 0x9e3779b97f4a7c15
+	h ^= h >> 32
+	return h & s.mask
+}
+
+// hashKey returns the 64 bit
+// fnv-1a hash of the key.
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
@@ -0,0 +1,125 @@
+package cache
+
+import "sync/atomic"
+
+// Stats reports how well a cache performs.
+type Stats struct {
+	// Hits is the number of lookups
+	// which found a valid key.
+	Hits uint64 `json:"hits"`
+
+	// Misses is the number of lookups
+	// which didn't find a valid key.
+	Misses uint64 `json:"misses"`
+
+	// Evictions is the number of keys removed
+	// to make room for new keys at capacity.
+	Evictions uint64 `json:"evictions"`
+
+	// Expirations is the number of expired keys
+	// removed by the background cleanup.
+	Expirations uint64 `json:"expirations"`
+
+	// LazyExpirations is the number of expired
+	// keys removed when they were looked up.
+	LazyExpirations uint64 `json:"lazy_expirations"`
+
+	// Rejections is the number of keys which
+	// weren't added because they were too large.
+	Rejections uint64 `json:"rejections"`
+
+	// Items is the number of keys in the cache.
+	Items int `json:"items"`
+
+	// Bytes is the number of bytes used by the
+	// entries in the cache, if it's tracked.
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// SampleCycles is the number of runs of the
+	// background cleanup, and SampleRounds is the
+	// number of samples of keys checked by them.
+	SampleCycles uint64 `json:"sample_cycles,omitempty"`
+	SampleRounds uint64 `json:"sample_rounds,omitempty"`
+
+	// SampleChecked is the number of keys
+	// checked by the background cleanup.
+	SampleChecked uint64 `json:"sample_checked,omitempty"`
+
+	// LastCycleChecked and LastCycleExpired are
+	// the number of keys checked and expired by
+	// the last run of the background cleanup.
+	LastCycleChecked uint64 `json:"last_cycle_checked,omitempty"`
+	LastCycleExpired uint64 `json:"last_cycle_expired,omitempty"`
+}
+
+// StatsReporter defines the behavior
+// for a cache which reports its stats.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// add returns the sum of the stats.
+func (s Stats) add(o Stats) Stats {
+	return Stats{
+		Hits:            s.Hits + o.Hits,
+		Misses:          s.Misses + o.Misses,
+		Evictions:       s.Evictions + o.Evictions,
+		Expirations:     s.Expirations + o.Expirations,
+		LazyExpirations: s.LazyExpirations + o.LazyExpirations,
+		Rejections:      s.Rejections + o.Rejections,
+		Items:           s.Items + o.Items,
+		Bytes:           s.Bytes + o.Bytes,
+
+		SampleCycles:     s.SampleCycles + o.SampleCycles,
+		SampleRounds:     s.SampleRounds + o.SampleRounds,
+		SampleChecked:    s.SampleChecked + o.SampleChecked,
+		LastCycleChecked: s.LastCycleChecked + o.LastCycleChecked,
+		LastCycleExpired: s.LastCycleExpired + o.LastCycleExpired,
+	}
+}
+
+// counters tracks the stats of a cache.
+// the fields are accessed atomically, so
+// counters must be the first field of the
+// cache to guarantee 64 bit alignment.
+type counters struct {
+	hits            uint64
+	misses          uint64
+	evictions       uint64
+	expirations     uint64
+	lazyExpirations uint64
+	rejections      uint64
+
+	sampleCycles     uint64
+	sampleRounds     uint64
+	sampleChecked    uint64
+	lastCycleChecked uint64
+	lastCycleExpired uint64
+}
+
+// stats returns a snapshot of the counters.
+func (c *counters) stats() Stats {
+	return Stats{
+		Hits:            atomic.LoadUint64(&c.hits),
+		Misses:          atomic.LoadUint64(&c.misses),
+		Evictions:       atomic.LoadUint64(&c.evictions),
+		Expirations:     atomic.LoadUint64(&c.expirations),
+		LazyExpirations: atomic.LoadUint64(&c.lazyExpirations),
+		Rejections:      atomic.LoadUint64(&c.rejections),
+
+		SampleCycles:     atomic.LoadUint64(&c.sampleCycles),
+		SampleRounds:     atomic.LoadUint64(&c.sampleRounds),
+		SampleChecked:    atomic.LoadUint64(&c.sampleChecked),
+		LastCycleChecked: atomic.LoadUint64(&c.lastCycleChecked),
+		LastCycleExpired: atomic.LoadUint64(&c.lastCycleExpired),
+	}
+}
+
+// hit records a lookup, and reports if it was a hit.
+func (c *counters) hit(ok bool) {
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+		return
+	}
+	atomic.AddUint64(&c.misses, 1)
+}
@@ -0,0 +1,330 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tinyLFUWindowPercent is the share of the capacity
+// used by the admission window. the rest is used by
+// the main cache.
+const tinyLFUWindowPercent = 0.01
+
+// tinyLFUProtectedPercent is the share of the
+// main cache used by the protected segment.
+const tinyLFUProtectedPercent = 0.8
+
+// tinyLFUSegment identifies the
+// segment an item belongs to.
+type tinyLFUSegment int
+
+const (
+	// window is the LRU admission window for new keys.
+	window tinyLFUSegment = iota
+
+	// probation holds the keys admitted into the
+	// main cache which haven't been accessed since.
+	probation
+
+	// protected holds the keys accessed
+	// while in the probation segment.
+	protected
+)
+
+// tinyLFUItem represents a single cache
+// entry for a W-TinyLFU cache.
+type tinyLFUItem struct {
+	*entry
+
+	// in is the segment holding the item.
+	in tinyLFUSegment
+
+	// element points to the item
+	// in the list for its segment.
+	element *list.Element
+}
+
+// tinyLFUCache implements the W-TinyLFU policy described
+// in "TinyLFU: A Highly Efficient Cache Admission Policy"
+// by Einziger, Friedman and Manes.
+// New keys are added to a small LRU window. Keys evicted
+// from the window are only admitted into the main segmented
+// LRU if they're estimated to be used more frequently than
+// the key they would replace. The frequencies are estimated
+// with a count-min sketch.
+type tinyLFUCache struct {
+	// counters tracks the stats
+	// for the cache.
+	counters
+
+	// evictHook reports the keys
+	// leaving the cache.
+	evictHook
+
+	// ttl defines the ttl for
+	// keys added to the cache.
+	ttl time.Duration
+
+	// clock is the source of time
+	// for the expiry of the keys.
+	clock Clock
+
+	// capacity of each segment.
+	windowCapacity    int
+	protectedCapacity int
+	mainCapacity      int
+
+	// this is the mutex protecting the
+	// lookupTable, the lists and the sketch.
+	// reads update the sketch, so a plain
+	// mutex is used.
+	sync.Mutex
+	lookupTable map[string]*tinyLFUItem
+	segments    [3]*list.List
+	sketch      *countMinSketch
+
+	// closed is set once the cache is closed.
+	closed bool
+}
+
+// ensure that the tinylfu cache supports
+// a ttl per key.
+var _ = TTLSetter(&tinyLFUCache{})
+var _ = CheckedSetter(&tinyLFUCache{})
+var _ = StatsReporter(&tinyLFUCache{})
+var _ = EvictNotifier(&tinyLFUCache{})
+var _ = EntryGetter(&tinyLFUCache{})
+
+// NewTinyLFUCache is used to initialize a W-TinyLFU cache.
+// It accepts the capacity of the cache, time to live
+// for the objects in the cache, and the options.
+// Expired keys are removed when they're looked up, or
+// evicted at capacity. Unlike the LRU cache, there is
+// no background cleanup.
+func NewTinyLFUCache(c int, t time.Duration, opts ...PolicyOption) Cacher {
+	if c < 2 {
+		c = 2
+	}
+	wc := int(tinyLFUWindowPercent * float64(c))
+	if wc < 1 {
+		wc = 1
+	}
+	mc := c - wc
+	tc := &tinyLFUCache{
+		ttl:               t,
+		clock:             newPolicyOptions(opts).clock,
+		windowCapacity:    wc,
+		mainCapacity:      mc,
+		protectedCapacity: int(tinyLFUProtectedPercent * float64(mc)),
+		lookupTable:       make(map[string]*tinyLFUItem, c),
+		sketch:            newCountMinSketch(c),
+	}
+	for i := range tc.segments {
+		tc.segments[i] = list.New()
+	}
+	return tc
+}
+
+// Get looks up the key in the W-TinyLFU cache. Every
+// lookup, including misses, is recorded in the sketch.
+// It returns an error if the key isn't present in the
+// cache.
+func (tc *tinyLFUCache) Get(key string) (string, error) {
+	e, err := tc.GetEntry(key)
+	return e.Value, err
+}
+
+// GetEntry looks up the key in the W-TinyLFU cache,
+// and returns its value along with its expiry.
+func (tc *tinyLFUCache) GetEntry(key string) (Entry, error) {
+	tc.Lock()
+	defer tc.unlock(tc)
+
+	if tc.closed {
+		return Entry{}, ErrClosed
+	}
+
+	tc.sketch.increment(key)
+
+	it, ok := tc.lookupTable[key]
+	if !ok {
+		tc.hit(false)
+		return Entry{}, ErrKeyNotFound
+	}
+	if it.expired(tc.clock.Now().UTC()) {
+		tc.remove(it, EvictExpired)
+		atomic.AddUint64(&tc.lazyExpirations, 1)
+		tc.hit(false)
+		return Entry{}, ErrKeyNotFound
+	}
+
+	tc.touch(it)
+	tc.hit(true)
+	return Entry{Value: it.value, Expiry: it.expiry}, nil
+}
+
+// Set adds the key value pair to the admission window
+// of the cache. The key evicted from the window competes
+// with the victim of the main cache for admission.
+func (tc *tinyLFUCache) Set(k, v string) {
+	tc.SetWithTTL(k, v, tc.ttl)
+}
+
+// SetWithTTL adds the key value pair to the cache with
+// the given time to live. A ttl <= 0 falls back to the
+// ttl the cache was initialized with.
+func (tc *tinyLFUCache) SetWithTTL(k, v string, ttl time.Duration) {
+	tc.TrySet(k, v, ttl)
+}
+
+// TrySet is the counterpart of SetWithTTL, which
+// returns ErrClosed if the cache is closed.
+func (tc *tinyLFUCache) TrySet(k, v string, ttl time.Duration) error {
+	e := newEntry(k, v, ttl, tc.ttl, tc.clock.Now())
+
+	tc.Lock()
+	defer tc.unlock(tc)
+
+	if tc.closed {
+		return ErrClosed
+	}
+
+	if it, ok := tc.lookupTable[k]; ok {
+		it.entry = e
+		tc.touch(it)
+		return nil
+	}
+
+	it := &tinyLFUItem{
+		entry: e,
+		in:    window,
+	}
+	it.element = tc.segments[window].PushFront(it)
+	tc.lookupTable[k] = it
+
+	if tc.segments[window].Len() <= tc.windowCapacity {
+		return nil
+	}
+
+	// the window is full, so its least recently
+	// used key becomes a candidate for admission.
+	candidate := tc.segments[window].Back().Value.(*tinyLFUItem)
+	if tc.segments[probation].Len()+tc.segments[protected].Len() < tc.mainCapacity {
+		tc.move(candidate, probation)
+		return nil
+	}
+
+	victim := tc.victim()
+	if tc.sketch.estimate(candidate.key) > tc.sketch.estimate(victim.key) {
+		tc.remove(victim, EvictCapacity)
+		tc.move(candidate, probation)
+	} else {
+		tc.remove(candidate, EvictCapacity)
+	}
+	atomic.AddUint64(&tc.evictions, 1)
+	return nil
+}
+
+// Delete removes the key from the cache.
+// It reports if the key was present.
+func (tc *tinyLFUCache) Delete(key string) bool {
+	tc.Lock()
+	defer tc.unlock(tc)
+
+	if tc.closed {
+		return false
+	}
+
+	it, ok := tc.lookupTable[key]
+	if !ok {
+		return false
+	}
+	tc.remove(it, EvictDeleted)
+	return true
+}
+
+// Flush removes all the keys from the cache,
+// and resets the frequency estimates.
+func (tc *tinyLFUCache) Flush() {
+	tc.Lock()
+	defer tc.Unlock()
+
+	if tc.closed {
+		return
+	}
+
+	tc.lookupTable = make(map[string]*tinyLFUItem, len(tc.lookupTable))
+	for i := range tc.segments {
+		tc.segments[i].Init()
+	}
+	tc.sketch = newCountMinSketch(tc.windowCapacity + tc.mainCapacity)
+}
+
+// Close releases the entries in the cache.
+// It is safe to call Close more than once.
+func (tc *tinyLFUCache) Close() error {
+	tc.Lock()
+	defer tc.Unlock()
+
+	tc.closed = true
+	tc.lookupTable = nil
+	for i := range tc.segments {
+		tc.segments[i].Init()
+	}
+	tc.sketch = nil
+	return nil
+}
+
+// Stats returns the stats for the cache.
+func (tc *tinyLFUCache) Stats() Stats {
+	tc.Lock()
+	defer tc.Unlock()
+
+	s := tc.stats()
+	s.Items = len(tc.lookupTable)
+	return s
+}
+
+// victim returns the key to be evicted from the
+// main cache. The caller must hold the lock.
+func (tc *tinyLFUCache) victim() *tinyLFUItem {
+	if tc.segments[probation].Len() > 0 {
+		return tc.segments[probation].Back().Value.(*tinyLFUItem)
+	}
+	return tc.segments[protected].Back().Value.(*tinyLFUItem)
+}
+
+// touch records a hit for the item. Items in the
+// probation segment are promoted to the protected
+// segment, which demotes its least recently used
+// item if it's full. The caller must hold the lock.
+func (tc *tinyLFUCache) touch(it *tinyLFUItem) {
+	switch it.in {
+	case window, protected:
+		tc.segments[it.in].MoveToFront(it.element)
+	case probation:
+		tc.move(it, protected)
+		if tc.segments[protected].Len() > tc.protectedCapacity {
+			tc.move(tc.segments[protected].Back().Value.(*tinyLFUItem), probation)
+		}
+	}
+}
+
+// move moves an item to the front of the given
+// segment. The caller must hold the lock.
+func (tc *tinyLFUCache) move(it *tinyLFUItem, to tinyLFUSegment) {
+	tc.segments[it.in].Remove(it.element)
+	it.in = to
+	it.element = tc.segments[to].PushFront(it)
+}
+
+// remove removes an item from the lookuptable and
+// its segment, for the given reason. The caller must
+// hold the lock.
+func (tc *tinyLFUCache) remove(it *tinyLFUItem, reason EvictReason) {
+	tc.segments[it.in].Remove(it.element)
+	delete(tc.lookupTable, it.key)
+	tc.evicted(it.key, it.value, reason)
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTinyLFU_Admission(t *testing.T) {
+	lc := NewTinyLFUCache(100, time.Hour*1)
+	c := lc.(*tinyLFUCache)
+
+	// fill the cache with keys which are
+	// accessed a few times each.
+	for i := 0; i < 100; i++ {
+		c.Get(key(i))
+		c.Set(key(i), value(i))
+		c.Get(key(i))
+		c.Get(key(i))
+	}
+
+	// keys seen only once are not admitted
+	// into the main cache.
+	for i := 1000; i < 1100; i++ {
+		c.Get(key(i))
+		c.Set(key(i), value(i))
+	}
+
+	found := 0
+	for i := 0; i < 100; i++ {
+		if _, err := c.Get(key(i)); err == nil {
+			found++
+		}
+	}
+	if found < 95 {
+		t.Fatalf("expected frequently used values to be retained, found: %d", found)
+	}
+	if len(c.lookupTable) > 100 {
+		t.Fatalf("expected the cache to hold at most its capacity")
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(100)
+	for i := 0; i < 5; i++ {
+		s.increment(key(0))
+	}
+	s.increment(key(1))
+
+	if s.estimate(key(0)) < 5 || s.estimate(key(1)) < 1 {
+		t.Fatalf("expected the sketch to not underestimate frequencies")
+	}
+
+	s.reset()
+	if s.estimate(key(0)) != 2 {
+		t.Fatalf("expected reset to halve the frequencies")
+	}
+
+	for i := 0; i < 100; i++ {
+		s.increment(key(0))
+	}
+	if s.estimate(key(0)) != sketchMaxCount {
+		t.Fatalf("expected the counters to saturate")
+	}
+}
@@ -1,10 +1,23 @@
 package mocks
 
-import "github.com/vikramsk/rediproxy/pkg/cache"
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/vikramsk/rediproxy/pkg/cache"
+)
 
 // ensure that the mocks satisfy the interfaces.
 var _ = cache.Getter(&Getter{})
+var _ = cache.TTLGetter(&TTLGetter{})
+var _ = cache.EntryGetter(&EntryGetter{})
+var _ = cache.ContextGetter(&ContextGetter{})
 var _ = cache.Setter(&Setter{})
+var _ = cache.TTLSetter(&TTLSetter{})
+var _ = cache.Deleter(&Deleter{})
+var _ = cache.Flusher(&Flusher{})
+var _ = io.Closer(&Closer{})
 
 // Getter is a mock implementation of
 // cache.Getter
@@ -13,6 +26,27 @@ type Getter struct {
 	GetFnInvoked bool
 }
 
+// TTLGetter is a mock implementation of
+// cache.TTLGetter
+type TTLGetter struct {
+	GetWithTTLFn        func(key string) (string, time.Duration, error)
+	GetWithTTLFnInvoked bool
+}
+
+// EntryGetter is a mock implementation of
+// cache.EntryGetter
+type EntryGetter struct {
+	GetEntryFn        func(key string) (cache.Entry, error)
+	GetEntryFnInvoked bool
+}
+
+// ContextGetter is a mock implementation of
+// cache.ContextGetter
+type ContextGetter struct {
+	GetContextFn        func(ctx context.Context, key string) (string, error)
+	GetContextFnInvoked bool
+}
+
 // Setter is a mock implementation of
 // cache.Writer
 type Setter struct {
@@ -20,14 +54,84 @@ type Setter struct {
 	SetFnInvoked bool
 }
 
+// TTLSetter is a mock implementation of
+// cache.TTLSetter
+type TTLSetter struct {
+	SetWithTTLFn        func(key, value string, ttl time.Duration)
+	SetWithTTLFnInvoked bool
+}
+
+// Deleter is a mock implementation of
+// cache.Deleter
+type Deleter struct {
+	DeleteFn        func(key string) bool
+	DeleteFnInvoked bool
+}
+
+// Flusher is a mock implementation of
+// cache.Flusher
+type Flusher struct {
+	FlushFn        func()
+	FlushFnInvoked bool
+}
+
+// Closer is a mock implementation of
+// io.Closer
+type Closer struct {
+	CloseFn        func() error
+	CloseFnInvoked bool
+}
+
 // Get is a mock implementation of the Get func.
 func (cr *Getter) Get(key string) (string, error) {
 	cr.GetFnInvoked = true
 	return cr.GetFn(key)
 }
 
+// GetWithTTL is a mock implementation of the GetWithTTL func.
+func (cr *TTLGetter) GetWithTTL(key string) (string, time.Duration, error) {
+	cr.GetWithTTLFnInvoked = true
+	return cr.GetWithTTLFn(key)
+}
+
+// GetEntry is a mock implementation of the GetEntry func.
+func (cr *EntryGetter) GetEntry(key string) (cache.Entry, error) {
+	cr.GetEntryFnInvoked = true
+	return cr.GetEntryFn(key)
+}
+
+// GetContext is a mock implementation of the GetContext func.
+func (cr *ContextGetter) GetContext(ctx context.Context, key string) (string, error) {
+	cr.GetContextFnInvoked = true
+	return cr.GetContextFn(ctx, key)
+}
+
 // Set is a mock implementation of the Set func.
 func (cw *Setter) Set(key, value string) {
 	cw.SetFnInvoked = true
 	cw.SetFn(key, value)
 }
+
+// SetWithTTL is a mock implementation of the SetWithTTL func.
+func (cw *TTLSetter) SetWithTTL(key, value string, ttl time.Duration) {
+	cw.SetWithTTLFnInvoked = true
+	cw.SetWithTTLFn(key, value, ttl)
+}
+
+// Delete is a mock implementation of the Delete func.
+func (cd *Deleter) Delete(key string) bool {
+	cd.DeleteFnInvoked = true
+	return cd.DeleteFn(key)
+}
+
+// Flush is a mock implementation of the Flush func.
+func (cf *Flusher) Flush() {
+	cf.FlushFnInvoked = true
+	cf.FlushFn()
+}
+
+// Close is a mock implementation of the Close func.
+func (cc *Closer) Close() error {
+	cc.CloseFnInvoked = true
+	return cc.CloseFn()
+}
@@ -0,0 +1,89 @@
+package mocks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vikramsk/rediproxy/pkg/cache"
+)
+
+// ensure that the mock satisfies the interface.
+var _ = cache.Clock(&Clock{})
+
+// waiter is a channel waiting
+// for the clock to reach a time.
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// Clock is a mock implementation of cache.Clock
+// which only moves when it's advanced by hand.
+type Clock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// NewClock initializes a mock clock set to the given time.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel which receives the time once
+// the clock has been advanced by at least the duration.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &waiter{
+		at: c.now.Add(d),
+		ch: make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		w.ch <- c.now
+		return w.ch
+	}
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+	return w.ch
+}
+
+// Advance moves the clock forward by the duration, and
+// fires the channels returned by After which are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+// BlockUntil blocks until n callers are waiting on the
+// channels returned by After. It's used to wait for a
+// background worker to go back to sleep.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a metric which only goes up.
+type Counter struct {
+	// bits holds the float64 value,
+	// and is accessed atomically.
+	bits uint64
+
+	labelValues []string
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by v.
+// Negative values are ignored.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		n := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&c.bits, old, n) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+// CounterVec is a group of counters which
+// share a name and are partitioned by labels.
+type CounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu       sync.RWMutex
+	counters map[string]*Counter
+}
+
+// ensure that the counter vec
+// implements the Collector interface.
+var _ = Collector(&CounterVec{})
+
+// NewCounterVec initializes a counter vec. It accepts
+// the name, the help text and the label names.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		counters:   make(map[string]*Counter),
+	}
+}
+
+// WithLabelValues returns the counter for the label
+// values, in the order of the label names.
+func (cv *CounterVec) WithLabelValues(values ...string) *Counter {
+	k := labelKey(values)
+
+	cv.mu.RLock()
+	c, ok := cv.counters[k]
+	cv.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	if c, ok = cv.counters[k]; !ok {
+		c = &Counter{labelValues: values}
+		cv.counters[k] = c
+	}
+	return c
+}
+
+// Collect writes the counters in
+// the text exposition format.
+func (cv *CounterVec) Collect(w io.Writer) {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+
+	writeHeader(w, cv.name, cv.help, "counter")
+	keys := make([]string, 0, len(cv.counters))
+	for k := range cv.counters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c := cv.counters[k]
+		writeSample(w, cv.name, formatLabels(cv.labelNames, c.labelValues), c.Value())
+	}
+}
@@ -0,0 +1,7 @@
+// Package metrics provides counters, gauges and
+// histograms which are exposed over HTTP in the
+// Prometheus text exposition format.
+//
+// It implements the subset of the Prometheus client
+// used by rediproxy, without any dependencies.
+package metrics
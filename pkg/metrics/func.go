@@ -0,0 +1,33 @@
+package metrics
+
+import "io"
+
+// funcMetric is a metric without labels
+// whose value is read when it's collected.
+type funcMetric struct {
+	name string
+	help string
+	typ  string
+	fn   func() float64
+}
+
+// NewGaugeFunc initializes a gauge whose value is
+// read from fn when it's collected. It accepts the
+// name, the help text and the func.
+func NewGaugeFunc(name, help string, fn func() float64) Collector {
+	return &funcMetric{name: name, help: help, typ: "gauge", fn: fn}
+}
+
+// NewCounterFunc initializes a counter whose value
+// is read from fn when it's collected. fn must only
+// ever return increasing values.
+func NewCounterFunc(name, help string, fn func() float64) Collector {
+	return &funcMetric{name: name, help: help, typ: "counter", fn: fn}
+}
+
+// Collect writes the metric in
+// the text exposition format.
+func (fm *funcMetric) Collect(w io.Writer) {
+	writeHeader(w, fm.name, fm.help, fm.typ)
+	writeSample(w, fm.name, "", fm.fn())
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// DefBuckets are the default buckets for
+// histograms of latencies in seconds.
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Histogram counts observations in
+// buckets with an upper bound.
+type Histogram struct {
+	mu sync.Mutex
+
+	// counts holds the number of observations
+	// for each bucket. the last one is +Inf.
+	counts []uint64
+	sum    float64
+	count  uint64
+
+	buckets     []float64
+	labelValues []string
+}
+
+// Observe adds a single observation.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec is a group of histograms which
+// share a name and are partitioned by labels.
+type HistogramVec struct {
+	name       string
+	help       string
+	buckets    []float64
+	labelNames []string
+
+	mu         sync.RWMutex
+	histograms map[string]*Histogram
+}
+
+// ensure that the histogram vec
+// implements the Collector interface.
+var _ = Collector(&HistogramVec{})
+
+// NewHistogramVec initializes a histogram vec. It accepts
+// the name, the help text, the sorted upper bounds of the
+// buckets and the label names.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{
+		name:       name,
+		help:       help,
+		buckets:    buckets,
+		labelNames: labelNames,
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+// WithLabelValues returns the histogram for the
+// label values, in the order of the label names.
+func (hv *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	k := labelKey(values)
+
+	hv.mu.RLock()
+	h, ok := hv.histograms[k]
+	hv.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	if h, ok = hv.histograms[k]; !ok {
+		h = &Histogram{
+			counts:      make([]uint64, len(hv.buckets)+1),
+			buckets:     hv.buckets,
+			labelValues: values,
+		}
+		hv.histograms[k] = h
+	}
+	return h
+}
+
+// Collect writes the histograms in
+// the text exposition format.
+func (hv *HistogramVec) Collect(w io.Writer) {
+	hv.mu.RLock()
+	defer hv.mu.RUnlock()
+
+	writeHeader(w, hv.name, hv.help, "histogram")
+	keys := make([]string, 0, len(hv.histograms))
+	for k := range hv.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hv.histograms[k].collect(w, hv.name, hv.labelNames)
+	}
+}
+
+// collect writes the cumulative buckets,
+// the sum and the count of the histogram.
+func (h *Histogram) collect(w io.Writer, name string, labelNames []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var cumulative uint64
+	for i, c := range h.counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(h.buckets) {
+			le = formatFloat(h.buckets[i])
+		}
+		writeSample(w, name+"_bucket", formatLabels(labelNames, h.labelValues, "le", le), float64(cumulative))
+	}
+	labels := formatLabels(labelNames, h.labelValues)
+	writeSample(w, name+"_sum", labels, h.sum)
+	writeSample(w, name+"_count", labels, float64(h.count))
+}
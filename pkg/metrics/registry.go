@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// contentType is the content type for the
+// Prometheus text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector defines the behavior for a
+// metric, or a group of metrics, which
+// can be written by a Registry.
+type Collector interface {
+	// Collect writes the metrics
+	// in the text exposition format.
+	Collect(w io.Writer)
+}
+
+// Registry holds the collectors
+// exposed by a metrics endpoint.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+// DefaultRegistry is the registry used
+// by MustRegister and Handler.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry initializes an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds the collector to the registry.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes the metrics of every collector in
+// the registry in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, c := range r.collectors {
+		c.Collect(cw)
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// ServeHTTP implements the http handler for the registry.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	r.WriteTo(w)
+}
+
+// MustRegister adds the collectors
+// to the DefaultRegistry.
+func MustRegister(cs ...Collector) {
+	for _, c := range cs {
+		DefaultRegistry.Register(c)
+	}
+}
+
+// Handler returns the http handler
+// for the DefaultRegistry.
+func Handler() http.Handler {
+	return DefaultRegistry
+}
+
+// countingWriter counts the bytes
+// written to the underlying writer.
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// writeHeader writes the HELP and
+// TYPE lines for a metric.
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// writeSample writes a single sample line.
+func writeSample(w io.Writer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(v))
+}
+
+// formatLabels formats the label pairs as {name="value",...}.
+// The extra pair, if not empty, is appended at the end.
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		pairs = append(pairs, n+`="`+escapeLabel(values[i])+`"`)
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, extra[0]+`="`+escapeLabel(extra[1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelKey joins the label values into
+// a key for the map of label sets.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
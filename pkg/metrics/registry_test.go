@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	cv := NewCounterVec("requests_total", "Number of requests.", "code")
+	cv.WithLabelValues("200").Add(2)
+	cv.WithLabelValues("500").Inc()
+	cv.WithLabelValues("404").Add(-1)
+
+	hv := NewHistogramVec("latency_seconds", "Latency of requests.", []float64{0.1, 1}, "code")
+	hv.WithLabelValues("200").Observe(0.05)
+	hv.WithLabelValues("200").Observe(0.5)
+	hv.WithLabelValues("200").Observe(5)
+
+	r.Register(cv)
+	r.Register(hv)
+	r.Register(NewGaugeFunc("items", "Number of \\items\n.", func() float64 { return 3 }))
+
+	expected := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{code="200"} 2
+requests_total{code="404"} 0
+requests_total{code="500"} 1
+# HELP latency_seconds Latency of requests.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{code="200",le="0.1"} 1
+latency_seconds_bucket{code="200",le="1"} 2
+latency_seconds_bucket{code="200",le="+Inf"} 3
+latency_seconds_sum{code="200"} 5.55
+latency_seconds_count{code="200"} 3
+# HELP items Number of \\items\n.
+# TYPE items gauge
+items 3
+`
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil || buf.String() != expected || n != int64(len(expected)) {
+		t.Fatalf("unexpected exposition, expected:\n%s\nreceived:\n%s", expected, buf.String())
+	}
+
+	req := httptest.NewRequest("GET", "http://test/metrics", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusOK || w.Body.String() != expected || w.Header().Get("Content-Type") != contentType {
+		t.Fatalf("expected the registry to serve the metrics")
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	cv := NewCounterVec("c", "help", "path")
+	cv.WithLabelValues("a\"b\\c\nd").Inc()
+
+	var buf bytes.Buffer
+	cv.Collect(&buf)
+	if !bytes.Contains(buf.Bytes(), []byte(`c{path="a\"b\\c\nd"} 1`)) {
+		t.Fatalf("expected the label value to be escaped, received:\n%s", buf.String())
+	}
+}
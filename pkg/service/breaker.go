@@ -0,0 +1,365 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vikramsk/rediproxy/pkg/cache"
+	"github.com/vikramsk/rediproxy/pkg/metrics"
+)
+
+// ErrCircuitOpen is the error returned when the
+// circuit breaker fails a call without making it.
+var ErrCircuitOpen = errors.New("service: circuit breaker open")
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = time.Second * 30
+
+	// minWindowCalls is the min. number of calls in
+	// a window for the error rate to be considered.
+	minWindowCalls = 20
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+// The states of a circuit breaker.
+const (
+	// BreakerClosed implies that
+	// calls are made as usual.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen implies that calls
+	// fail with ErrCircuitOpen.
+	BreakerOpen
+
+	// BreakerHalfOpen implies that a single
+	// call is made to probe for recovery.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerStats reports the state
+// of a circuit breaker.
+type BreakerStats struct {
+	// State is the current state.
+	State string `json:"state"`
+
+	// Failures is the number of
+	// consecutive failed calls.
+	Failures int `json:"failures"`
+
+	// Opens is the number of times
+	// the circuit breaker opened.
+	Opens uint64 `json:"opens"`
+
+	// Rejected is the number of calls failed
+	// with ErrCircuitOpen without being made.
+	Rejected uint64 `json:"rejected"`
+}
+
+// BreakerStatsReporter defines the behavior
+// for a circuit breaker which reports its stats.
+type BreakerStatsReporter interface {
+	BreakerStats() BreakerStats
+}
+
+// ensure that the circuit breaker
+// keeps the expiry of the keys.
+var _ = cache.TTLGetter(&circuitBreaker{})
+var _ = cache.ContextGetter(&circuitBreaker{})
+var _ = cache.ContextTTLGetter(&circuitBreaker{})
+var _ = BreakerStatsReporter(&circuitBreaker{})
+var _ = InvalidationNotifier(&circuitBreaker{})
+
+type circuitBreaker struct {
+	getter cache.Getter
+
+	// failureThreshold is the number of consecutive
+	// failed calls which open the circuit breaker.
+	// a value <= 0 disables it.
+	failureThreshold int
+
+	// errorRate is the fraction of failed calls
+	// within a window which opens the circuit
+	// breaker. a value <= 0 disables it.
+	errorRate float64
+	window    time.Duration
+
+	// openTimeout is the time the circuit
+	// breaker stays open before it probes
+	// for recovery.
+	openTimeout time.Duration
+
+	// now returns the current time.
+	now func() time.Time
+
+	// this is the mutex protecting
+	// the state of the breaker.
+	sync.Mutex
+	state        BreakerState
+	failures     int
+	openedAt     time.Time
+	probing      bool
+	windowStart  time.Time
+	windowCalls  int
+	windowErrors int
+	opens        uint64
+	rejected     uint64
+}
+
+// BreakerOption configures the circuit breaker.
+type BreakerOption func(*circuitBreaker)
+
+// WithFailureThreshold sets the number of consecutive
+// failed calls which open the circuit breaker. A value
+// <= 0 disables it. It defaults to 5.
+func WithFailureThreshold(n int) BreakerOption {
+	return func(cb *circuitBreaker) {
+		cb.failureThreshold = n
+	}
+}
+
+// WithErrorRate sets the fraction of failed calls within
+// a window which opens the circuit breaker. The rate is
+// only considered after 20 calls in the window. A rate
+// <= 0 disables it, which is the default.
+func WithErrorRate(rate float64, window time.Duration) BreakerOption {
+	return func(cb *circuitBreaker) {
+		cb.errorRate = rate
+		cb.window = window
+	}
+}
+
+// WithOpenTimeout sets the time the circuit breaker
+// stays open, before a single call is made to probe
+// for recovery. It defaults to 30s.
+func WithOpenTimeout(d time.Duration) BreakerOption {
+	return func(cb *circuitBreaker) {
+		cb.openTimeout = d
+	}
+}
+
+// NewCircuitBreaker wraps the backing store with a circuit
+// breaker. Calls which fail with an error other than
+// cache.ErrKeyNotFound are counted as failures. Once the
+// breaker opens, calls fail with ErrCircuitOpen until it
+// probes the backing store for recovery.
+func NewCircuitBreaker(g cache.Getter, opts ...BreakerOption) cache.Getter {
+	cb := &circuitBreaker{
+		getter:           g,
+		failureThreshold: defaultFailureThreshold,
+		openTimeout:      defaultOpenTimeout,
+		now:              time.Now,
+	}
+	for _, opt := range opts {
+		opt(cb)
+	}
+	cb.windowStart = cb.now()
+	return cb
+}
+
+// Get fetches the key from the backing
+// store, unless the breaker is open.
+func (cb *circuitBreaker) Get(key string) (string, error) {
+	return cb.GetContext(context.Background(), key)
+}
+
+// GetContext fetches the key from the backing store,
+// unless the breaker is open. The context is passed
+// on if the backing store is a cache.ContextGetter.
+func (cb *circuitBreaker) GetContext(ctx context.Context, key string) (string, error) {
+	if err := cb.allow(); err != nil {
+		return "", err
+	}
+
+	var val string
+	var err error
+	if cg, ok := cb.getter.(cache.ContextGetter); ok {
+		val, err = cg.GetContext(ctx, key)
+	} else {
+		val, err = cb.getter.Get(key)
+	}
+	cb.done(err)
+	return val, err
+}
+
+// GetWithTTL fetches the key along with its expiry from
+// the backing store, unless the breaker is open. The ttl
+// is 0 if the backing store doesn't report it.
+func (cb *circuitBreaker) GetWithTTL(key string) (string, time.Duration, error) {
+	return cb.GetWithTTLContext(context.Background(), key)
+}
+
+// GetWithTTLContext is the context aware
+// counterpart of GetWithTTL.
+func (cb *circuitBreaker) GetWithTTLContext(ctx context.Context, key string) (string, time.Duration, error) {
+	var get func() (string, time.Duration, error)
+	switch g := cb.getter.(type) {
+	case cache.ContextTTLGetter:
+		get = func() (string, time.Duration, error) { return g.GetWithTTLContext(ctx, key) }
+	case cache.TTLGetter:
+		get = func() (string, time.Duration, error) { return g.GetWithTTL(key) }
+	default:
+		val, err := cb.GetContext(ctx, key)
+		return val, 0, err
+	}
+
+	if err := cb.allow(); err != nil {
+		return "", 0, err
+	}
+	val, ttl, err := get()
+	cb.done(err)
+	return val, ttl, err
+}
+
+// BreakerStats returns the stats for the breaker.
+func (cb *circuitBreaker) BreakerStats() BreakerStats {
+	cb.Lock()
+	defer cb.Unlock()
+
+	return BreakerStats{
+		State:    cb.state.String(),
+		Failures: cb.failures,
+		Opens:    cb.opens,
+		Rejected: cb.rejected,
+	}
+}
+
+// OnInvalidate sets the func called with the keys modified
+// in the backing store, if it's an InvalidationNotifier.
+func (cb *circuitBreaker) OnInvalidate(fn InvalidateFunc) {
+	if n, ok := cb.getter.(InvalidationNotifier); ok {
+		n.OnInvalidate(fn)
+	}
+}
+
+// allow reports if a call can be made. An open breaker
+// moves to half-open after the open timeout, and lets
+// through a single call at a time.
+func (cb *circuitBreaker) allow() error {
+	cb.Lock()
+	defer cb.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if cb.now().Sub(cb.openedAt) < cb.openTimeout {
+			cb.rejected++
+			return ErrCircuitOpen
+		}
+		cb.state = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if cb.probing {
+			cb.rejected++
+			return ErrCircuitOpen
+		}
+		cb.probing = true
+	}
+	return nil
+}
+
+// done records the result of a call. Calls
+// canceled by the caller aren't failures.
+func (cb *circuitBreaker) done(err error) {
+	cb.Lock()
+	defer cb.Unlock()
+
+	failed := err != nil && err != cache.ErrKeyNotFound && err != context.Canceled
+	switch cb.state {
+	case BreakerOpen:
+		// the call was made before
+		// the breaker opened.
+		return
+	case BreakerHalfOpen:
+		cb.probing = false
+		if err == context.Canceled {
+			// a canceled probe says nothing about
+			// the backing store, so the next call
+			// probes it again.
+			return
+		}
+		if failed {
+			cb.open()
+			return
+		}
+		cb.state = BreakerClosed
+		cb.failures = 0
+		cb.resetWindow()
+		return
+	}
+
+	if cb.window > 0 && cb.now().Sub(cb.windowStart) >= cb.window {
+		cb.resetWindow()
+	}
+	cb.windowCalls++
+	if !failed {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	cb.windowErrors++
+
+	if cb.failureThreshold > 0 && cb.failures >= cb.failureThreshold {
+		cb.open()
+		return
+	}
+	if cb.errorRate > 0 && cb.windowCalls >= minWindowCalls &&
+		float64(cb.windowErrors)/float64(cb.windowCalls) >= cb.errorRate {
+		cb.open()
+	}
+}
+
+// open opens the breaker. The
+// caller must hold the lock.
+func (cb *circuitBreaker) open() {
+	cb.state = BreakerOpen
+	cb.openedAt = cb.now()
+	cb.opens++
+}
+
+// resetWindow starts a new window for the
+// error rate. The caller must hold the lock.
+func (cb *circuitBreaker) resetWindow() {
+	cb.windowStart = cb.now()
+	cb.windowCalls = 0
+	cb.windowErrors = 0
+}
+
+// NewBreakerCollectors initializes the metrics for the
+// circuit breaker, which are read from its stats when
+// they're collected. The metric names are prefixed with
+// rediproxy_breaker.
+func NewBreakerCollectors(br BreakerStatsReporter) []metrics.Collector {
+	return []metrics.Collector{
+		metrics.NewGaugeFunc("rediproxy_breaker_state", "State of the circuit breaker: 0 closed, 1 open, 2 half-open.",
+			func() float64 {
+				switch br.BreakerStats().State {
+				case BreakerOpen.String():
+					return float64(BreakerOpen)
+				case BreakerHalfOpen.String():
+					return float64(BreakerHalfOpen)
+				}
+				return float64(BreakerClosed)
+			}),
+		metrics.NewCounterFunc("rediproxy_breaker_opens_total", "Number of times the circuit breaker opened.",
+			func() float64 { return float64(br.BreakerStats().Opens) }),
+		metrics.NewCounterFunc("rediproxy_breaker_rejected_total", "Number of calls failed by the circuit breaker without being made.",
+			func() float64 { return float64(br.BreakerStats().Rejected) }),
+	}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vikramsk/rediproxy/pkg/cache"
+	"github.com/vikramsk/rediproxy/pkg/internal/mocks"
+)
+
+var errInternal = errors.New("internal error")
+
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	now := time.Now()
+	mBacking := &mocks.Getter{GetFn: internalError}
+	cb := NewCircuitBreaker(mBacking,
+		WithFailureThreshold(3),
+		WithOpenTimeout(time.Second*10),
+	).(*circuitBreaker)
+	cb.now = func() time.Time { return now }
+
+	// missing keys aren't failures.
+	mBacking.GetFn = cacheMiss
+	for i := 0; i < 5; i++ {
+		cb.Get("key")
+	}
+	mBacking.GetFn = internalError
+	for i := 0; i < 3; i++ {
+		if _, err := cb.Get("key"); err == ErrCircuitOpen {
+			t.Fatalf("expected the breaker to be closed before the threshold")
+		}
+	}
+
+	mBacking.GetFnInvoked = false
+	if _, err := cb.Get("key"); err != ErrCircuitOpen || mBacking.GetFnInvoked {
+		t.Fatalf("expected the open breaker to fail fast, received: %v", err)
+	}
+
+	// after the timeout, a failed
+	// probe opens the breaker again.
+	now = now.Add(time.Second * 10)
+	if _, err := cb.Get("key"); err == ErrCircuitOpen || !mBacking.GetFnInvoked {
+		t.Fatalf("expected the half-open breaker to probe the backing store")
+	}
+	if _, err := cb.Get("key"); err != ErrCircuitOpen {
+		t.Fatalf("expected a failed probe to open the breaker, received: %v", err)
+	}
+
+	// a successful probe closes the breaker.
+	now = now.Add(time.Second * 10)
+	mBacking.GetFn = cacheHit
+	for i := 0; i < 2; i++ {
+		if val, err := cb.Get("key"); val != "value" || err != nil {
+			t.Fatalf("expected a successful probe to close the breaker, received: %v", err)
+		}
+	}
+
+	expected := BreakerStats{State: "closed", Failures: 0, Opens: 2, Rejected: 2}
+	if s := cb.BreakerStats(); s != expected {
+		t.Fatalf("unexpected stats, expected: %+v, received: %+v", expected, s)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenSingleProbe(t *testing.T) {
+	now := time.Now()
+	backing := &blockingGetter{release: make(chan struct{})}
+	cb := NewCircuitBreaker(backing, WithFailureThreshold(1)).(*circuitBreaker)
+	cb.now = func() time.Time { return now }
+
+	cb.done(errInternal)
+	now = now.Add(defaultOpenTimeout)
+
+	probe := make(chan error)
+	go func() {
+		_, err := cb.Get("key")
+		probe <- err
+	}()
+	for cb.BreakerStats().State != "half-open" || !cb.probing {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := cb.Get("key"); err != ErrCircuitOpen {
+		t.Fatalf("expected calls during the probe to fail fast, received: %v", err)
+	}
+	close(backing.release)
+	if err := <-probe; err != nil {
+		t.Fatalf("expected the probe to succeed, received: %v", err)
+	}
+	if s := cb.BreakerStats(); s.State != "closed" {
+		t.Fatalf("expected a successful probe to close the breaker, stats: %+v", s)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenCanceledProbe(t *testing.T) {
+	now := time.Now()
+	mBacking := &mocks.Getter{GetFn: func(key string) (string, error) {
+		return "", context.Canceled
+	}}
+	cb := NewCircuitBreaker(mBacking, WithFailureThreshold(1)).(*circuitBreaker)
+	cb.now = func() time.Time { return now }
+
+	cb.done(errInternal)
+	now = now.Add(defaultOpenTimeout)
+
+	if _, err := cb.Get("key"); err != context.Canceled {
+		t.Fatalf("expected the probe to be canceled, received: %v", err)
+	}
+	if s := cb.BreakerStats(); s.State != "half-open" || cb.probing {
+		t.Fatalf("expected a canceled probe to leave the breaker half-open, stats: %+v", s)
+	}
+
+	// the next call probes the backing store.
+	mBacking.GetFn = cacheHit
+	if val, err := cb.Get("key"); val != "value" || err != nil {
+		t.Fatalf("expected the next call to probe the backing store, received: %v", err)
+	}
+	if s := cb.BreakerStats(); s.State != "closed" {
+		t.Fatalf("expected a successful probe to close the breaker, stats: %+v", s)
+	}
+}
+
+func TestCircuitBreaker_ErrorRate(t *testing.T) {
+	now := time.Now()
+	calls := 0
+	mBacking := &mocks.Getter{GetFn: func(key string) (string, error) {
+		calls++
+		if calls%2 == 0 {
+			return "", errInternal
+		}
+		return "value", nil
+	}}
+	cb := NewCircuitBreaker(mBacking,
+		WithFailureThreshold(0),
+		WithErrorRate(0.5, time.Minute),
+	).(*circuitBreaker)
+	cb.now = func() time.Time { return now }
+	cb.resetWindow()
+
+	// the rate is only considered after
+	// the min. number of calls.
+	for i := 0; i < minWindowCalls-1; i++ {
+		cb.Get("key")
+	}
+	if s := cb.BreakerStats(); s.State != "closed" {
+		t.Fatalf("expected the breaker to wait for enough calls, stats: %+v", s)
+	}
+
+	// a new window forgets the failures.
+	now = now.Add(time.Minute)
+	cb.Get("key")
+	cb.Get("key")
+	if s := cb.BreakerStats(); s.State != "closed" {
+		t.Fatalf("expected a new window to reset the error rate, stats: %+v", s)
+	}
+
+	for i := 0; i < minWindowCalls; i++ {
+		cb.Get("key")
+	}
+	if s := cb.BreakerStats(); s.State != "open" {
+		t.Fatalf("expected the error rate to open the breaker, stats: %+v", s)
+	}
+}
+
+func TestCircuitBreaker_Proxy(t *testing.T) {
+	mBacking := &mockTTLGetter{
+		Getter: &mocks.Getter{GetFn: internalError},
+		TTLGetter: &mocks.TTLGetter{GetWithTTLFn: func(key string) (string, time.Duration, error) {
+			return "", 0, errInternal
+		}},
+	}
+	lc := cache.NewLRUCache(100, time.Hour)
+	defer lc.Close()
+	pc := NewCacheProxy(NewCircuitBreaker(mBacking, WithFailureThreshold(2)), lc)
+
+	for i := 0; i < 4; i++ {
+		pc.Get("key")
+	}
+
+	s := pc.(StatsReporter).Stats()
+	if s.Breaker == nil || s.Breaker.State != "open" || s.Breaker.Rejected != 2 {
+		t.Fatalf("expected the breaker stats to be reported, stats: %+v", s.Breaker)
+	}
+	if s.Backend.Calls != 2 || s.Backend.Errors != 2 {
+		t.Fatalf("expected calls failed by the breaker not to be recorded, stats: %+v", s.Backend)
+	}
+}
+
+func TestCircuitBreaker_Canceled(t *testing.T) {
+	mBacking := &mockContextGetter{
+		Getter: &mocks.Getter{GetFn: cacheHit},
+		ContextGetter: &mocks.ContextGetter{GetContextFn: func(ctx context.Context, key string) (string, error) {
+			return "", ctx.Err()
+		}},
+	}
+	cb := NewCircuitBreaker(mBacking, WithFailureThreshold(1)).(*circuitBreaker)
+
+	// calls canceled by the caller aren't failures.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cb.GetContext(ctx, "key"); err != context.Canceled {
+		t.Fatalf("expected the context error, received: %v", err)
+	}
+	if s := cb.BreakerStats(); s.State != "closed" || s.Failures != 0 {
+		t.Fatalf("expected canceled calls not to open the breaker, stats: %+v", s)
+	}
+
+	// calls which time out are failures.
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	cb.GetContext(ctx, "key")
+	if s := cb.BreakerStats(); s.State != "open" {
+		t.Fatalf("expected timed out calls to open the breaker, stats: %+v", s)
+	}
+}
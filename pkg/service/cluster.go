@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/vikramsk/rediproxy/pkg/cache"
+)
+
+// ClusterOptions configures a backend on a Redis Cluster.
+type ClusterOptions struct {
+	// Addrs are the host:port addresses of the seed nodes.
+	// The other nodes are discovered from the slots of the
+	// cluster.
+	Addrs []string
+
+	// ReadFromReplicas sends the lookups to the replicas
+	// of the slot for the key, which may lag behind it.
+	ReadFromReplicas bool
+
+	// RefreshInterval is the time between the reloads of
+	// the slots of the cluster. The slots are also reloaded
+	// on MOVED redirects and when they're older than a minute,
+	// which is the only reload if the interval is 0.
+	RefreshInterval time.Duration
+
+	// RedisOptions configures the connections to the
+	// nodes. The address is unused, unless it's a URL
+	// with the credentials. The database must be 0.
+	RedisOptions
+}
+
+// ensure that the cluster client reports
+// the expiry of the keys.
+var _ = cache.TTLGetter(&clusterClient{})
+var _ = cache.ContextTTLGetter(&clusterClient{})
+var _ = io.Closer(&clusterClient{})
+
+// clusterClient is a redis client which
+// reloads the slots of the cluster.
+type clusterClient struct {
+	*redisClient
+	cluster *redis.ClusterClient
+
+	// done is closed once, by Close.
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewClusterClient initializes a backend on the cluster,
+// which routes the keys to the node for their hash slot
+// and follows the MOVED and ASK redirects of the nodes.
+func NewClusterClient(o ClusterOptions) (cache.Getter, error) {
+	if len(o.Addrs) == 0 {
+		return nil, errors.New("service: cluster requires the addresses of the seed nodes")
+	}
+	ro, err := o.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if ro.DB != 0 {
+		return nil, errors.New("service: redis cluster only supports the database 0")
+	}
+	opt, err := o.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:        o.Addrs,
+		ReadOnly:     o.ReadFromReplicas,
+		OnConnect:    opt.OnConnect,
+		Password:     opt.Password,
+		DialTimeout:  opt.DialTimeout,
+		ReadTimeout:  opt.ReadTimeout,
+		WriteTimeout: opt.WriteTimeout,
+		PoolSize:     opt.PoolSize,
+		MinIdleConns: opt.MinIdleConns,
+		TLSConfig:    opt.TLSConfig,
+	})
+	if err := cluster.ReloadState(); err != nil {
+		cluster.Close()
+		return nil, fmt.Errorf("service: could not initialize redis cluster client. err: %v", err)
+	}
+
+	rc := &redisClient{cluster}
+	if o.RefreshInterval <= 0 {
+		return rc, nil
+	}
+
+	cc := &clusterClient{
+		redisClient: rc,
+		cluster:     cluster,
+		done:        make(chan struct{}),
+	}
+	go cc.refresh(o.RefreshInterval)
+	return cc, nil
+}
+
+// refresh reloads the slots of the cluster
+// on every tick, until the client is closed.
+// A failed reload keeps the previous slots.
+func (cc *clusterClient) refresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cc.cluster.ReloadState()
+		case <-cc.done:
+			return
+		}
+	}
+}
+
+// Close stops the reloads of the slots, and
+// closes the connections to the nodes. It is
+// safe to call more than once.
+func (cc *clusterClient) Close() (err error) {
+	cc.closeOnce.Do(func() {
+		close(cc.done)
+		err = cc.redisClient.Close()
+	})
+	return err
+}
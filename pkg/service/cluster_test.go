@@ -0,0 +1,81 @@
+package service
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var clusterAddr = flag.String("cluster-addr", "", "URL for a node of a Redis Cluster. the cluster tests are skipped if it's not set")
+
+func TestNewClusterClient_Invalid(t *testing.T) {
+	scenarios := []struct {
+		name string
+		opts ClusterOptions
+	}{
+		{
+			name: "no seed nodes",
+			opts: ClusterOptions{},
+		},
+		{
+			name: "database other than 0",
+			opts: ClusterOptions{Addrs: []string{*redisURL}, RedisOptions: RedisOptions{DB: 1}},
+		},
+		{
+			name: "database other than 0 in the url",
+			opts: ClusterOptions{Addrs: []string{*redisURL}, RedisOptions: RedisOptions{Addr: "redis://localhost/1"}},
+		},
+		{
+			name: "node without cluster support",
+			opts: ClusterOptions{Addrs: []string{*redisURL}},
+		},
+	}
+
+	for _, s := range scenarios {
+		if cc, err := NewClusterClient(s.opts); err == nil || cc != nil {
+			t.Errorf("expected a failure in client creation for: %s", s.name)
+		}
+	}
+}
+
+func TestClusterClient_Close(t *testing.T) {
+	cc := &clusterClient{
+		redisClient: &redisClient{redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})},
+		done:        make(chan struct{}),
+	}
+
+	if cc.Close() != nil || cc.Close() != nil {
+		t.Fatalf("expected close to be safe to call more than once")
+	}
+}
+
+func TestClusterClient(t *testing.T) {
+	if *clusterAddr == "" {
+		t.Skip("cluster-addr isn't set")
+	}
+
+	cc, err := NewClusterClient(ClusterOptions{
+		Addrs:           []string{*clusterAddr},
+		RefreshInterval: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("expected client to be created, err: %v", err)
+	}
+	c := cc.(*clusterClient)
+	defer c.Close()
+
+	// the keys are spread across the slots,
+	// and so across the nodes of the cluster.
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("clusterKey%d", i)
+		c.client.Set(key, "value", time.Minute)
+
+		val, ttl, err := c.GetWithTTL(key)
+		if err != nil || val != "value" || ttl <= 0 {
+			t.Fatalf("expected key %s to be read from the node for its slot, err: %v", key, err)
+		}
+	}
+}
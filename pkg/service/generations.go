@@ -0,0 +1,92 @@
+package service
+
+import "sync"
+
+// keyGenerations counts the invalidations of the keys
+// while they're loaded from the backing store, so that
+// a load which raced with an invalidation of its key
+// doesn't add the stale value to the caches. Only the
+// keys being loaded are tracked.
+type keyGenerations struct {
+	mu sync.Mutex
+
+	// flushes counts the invalidations
+	// of every key.
+	flushes uint64
+
+	// loading holds the keys being loaded.
+	loading map[string]*keyGeneration
+}
+
+// keyGeneration counts the loads in flight
+// for a key, and the invalidations of the
+// key while they were.
+type keyGeneration struct {
+	loads         int
+	invalidations uint64
+}
+
+// generation identifies the invalidations
+// seen by a load when it started.
+type generation struct {
+	flushes       uint64
+	invalidations uint64
+}
+
+// start records a load of the key, and returns the
+// generation to be passed to finish once it's done.
+func (kg *keyGenerations) start(key string) generation {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+
+	if kg.loading == nil {
+		kg.loading = make(map[string]*keyGeneration)
+	}
+	g, ok := kg.loading[key]
+	if !ok {
+		g = &keyGeneration{}
+		kg.loading[key] = g
+	}
+	g.loads++
+	return generation{kg.flushes, g.invalidations}
+}
+
+// finish records the end of a load of the key, and
+// calls fn unless the key was invalidated since the
+// load started. fn is called with the lock held, so
+// an invalidation either drops the load or is applied
+// after fn. It reports if fn was called.
+func (kg *keyGenerations) finish(key string, gen generation, fn func()) bool {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+
+	g := kg.loading[key]
+	g.loads--
+	if g.loads == 0 {
+		delete(kg.loading, key)
+	}
+	if gen != (generation{kg.flushes, g.invalidations}) {
+		return false
+	}
+	fn()
+	return true
+}
+
+// invalidate drops the loads in flight for the
+// keys. Every load is dropped if the keys are nil.
+// It must be called before the keys are removed
+// from the caches.
+func (kg *keyGenerations) invalidate(keys []string) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+
+	if keys == nil {
+		kg.flushes++
+		return
+	}
+	for _, key := range keys {
+		if g, ok := kg.loading[key]; ok {
+			g.invalidations++
+		}
+	}
+}
@@ -1,22 +1,171 @@
 package service
 
 import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/vikramsk/rediproxy/pkg/cache"
 )
 
+// ensure that the proxy reports its
+// stats and supports removing keys.
+var _ = StatsReporter(&cacheProxy{})
+var _ = cache.Deleter(&cacheProxy{})
+var _ = cache.Flusher(&cacheProxy{})
+var _ = cache.ContextGetter(&cacheProxy{})
+var _ = io.Closer(&cacheProxy{})
+
 type cacheProxy struct {
+	// counters tracks the stats for
+	// the calls to the backing store.
+	counters backendCounters
+
+	// flights coalesces concurrent loads
+	// for the same key.
+	flights flightGroup
+
+	// generations drops the loads which raced
+	// with an invalidation of their key.
+	generations keyGenerations
+
 	lruCache      cache.Cacher
 	backingClient cache.Getter
+
+	// negativeCache holds the keys which were
+	// missing in the backing store, if it's set.
+	negativeCache cache.Cacher
+
+	// ttl is the default time to live for
+	// the keys added to the in-memory cache.
+	ttl time.Duration
+
+	// keyTTL resolves the time to live for the
+	// keys added to the in-memory cache. It is
+	// only used if the cache is a cache.TTLSetter.
+	keyTTL func(key string) time.Duration
+
+	// staleWindow is the time after the ttl of a
+	// key during which the key is still served,
+	// while it's refreshed in the background.
+	staleWindow time.Duration
+
+	// gracePeriod is the time after the ttl of a
+	// key during which the key is served if it
+	// can't be fetched from the backing store.
+	gracePeriod time.Duration
+
+	// retention is the time keys are kept in the
+	// in-memory cache after their ttl. It is the
+	// larger of the stale window and grace period.
+	retention time.Duration
+
+	// backendTimeout is the max. time a call to
+	// the backing store takes, if it's set.
+	backendTimeout time.Duration
+
+	// refreshing tracks the background refreshes,
+	// which aren't started once the proxy is closed.
+	mu         sync.Mutex
+	closed     bool
+	refreshing sync.WaitGroup
+}
+
+// ProxyOption configures the cache proxy.
+type ProxyOption func(*cacheProxy)
+
+// WithTTL sets the default time to live for keys fetched
+// from the backing store. It is capped at the expiry of the
+// key in the backing store, if the store reports one.
+func WithTTL(ttl time.Duration) ProxyOption {
+	return func(cp *cacheProxy) {
+		cp.ttl = ttl
+	}
+}
+
+// WithKeyTTL sets the func used to resolve the time to live
+// for keys fetched from the backing store. A ttl <= 0 falls
+// back to the default ttl of the in-memory cache.
+func WithKeyTTL(fn func(key string) time.Duration) ProxyOption {
+	return func(cp *cacheProxy) {
+		cp.keyTTL = fn
+	}
+}
+
+// WithStaleWhileRevalidate sets the time after the ttl of a
+// key during which lookups are served the cached value, while
+// a single background call refreshes it from the backing store.
+// Only lookups after the window wait for the backing store. It
+// requires the in-memory cache to be a cache.TTLSetter and a
+// cache.EntryGetter, and a default ttl to be set with WithTTL.
+func WithStaleWhileRevalidate(window time.Duration) ProxyOption {
+	return func(cp *cacheProxy) {
+		cp.staleWindow = window
+	}
+}
+
+// WithStaleIfError sets the time after the ttl of a key during
+// which lookups are served the cached value if the backing store
+// fails with an error other than cache.ErrKeyNotFound. The value
+// is returned along with a *cache.StaleError. It has the same
+// requirements as WithStaleWhileRevalidate.
+func WithStaleIfError(grace time.Duration) ProxyOption {
+	return func(cp *cacheProxy) {
+		cp.gracePeriod = grace
+	}
+}
+
+// WithNegativeCache sets the cache for the keys which are
+// missing in the backing store. Lookups for those keys return
+// cache.ErrKeyNotFound without calling the backing store, until
+// they expire from the cache. The values in the cache are unused,
+// so it should have a shorter ttl than the in-memory cache.
+func WithNegativeCache(nc cache.Cacher) ProxyOption {
+	return func(cp *cacheProxy) {
+		cp.negativeCache = nc
+	}
+}
+
+// WithBackendTimeout sets the max. time a call to the backing
+// store takes. Calls which take longer fail with
+// context.DeadlineExceeded. The timeout is only enforced if the
+// backing store is a cache.ContextGetter or cache.ContextTTLGetter.
+func WithBackendTimeout(d time.Duration) ProxyOption {
+	return func(cp *cacheProxy) {
+		cp.backendTimeout = d
+	}
 }
 
 // NewCacheProxy initializes the primary cache proxy service.
 // It accepts the interfaces for the backing cache store and
 // the in memory cache.
-func NewCacheProxy(c cache.Getter, lc cache.Cacher) cache.Getter {
-	return &cacheProxy{
+func NewCacheProxy(c cache.Getter, lc cache.Cacher, opts ...ProxyOption) cache.Getter {
+	cp := &cacheProxy{
 		backingClient: c,
 		lruCache:      lc,
 	}
+	for _, opt := range opts {
+		opt(cp)
+	}
+
+	// stale keys can't be told apart
+	// without their expiry.
+	if _, ok := lc.(cache.EntryGetter); !ok {
+		cp.staleWindow, cp.gracePeriod = 0, 0
+	}
+	cp.retention = cp.staleWindow
+	if cp.gracePeriod > cp.retention {
+		cp.retention = cp.gracePeriod
+	}
+
+	// drop the keys modified in the backing
+	// store, if it reports them.
+	if n, ok := c.(InvalidationNotifier); ok {
+		n.OnInvalidate(cp.invalidate)
+	}
+	return cp
 }
 
 // Get returns the value for a given key.
@@ -24,19 +173,270 @@ func NewCacheProxy(c cache.Getter, lc cache.Cacher) cache.Getter {
 // doesn't find it there, it fetches the data from
 // the backing cache store.
 func (cp *cacheProxy) Get(key string) (string, error) {
+	return cp.GetContext(context.Background(), key)
+}
+
+// GetContext is the context aware counterpart of Get.
+// The context is passed on to the backing store, and
+// the lookup fails with the error of the context once
+// it's done.
+func (cp *cacheProxy) GetContext(ctx context.Context, key string) (string, error) {
 	// lookup key in the in-memory cache.
-	val, err := cp.lruCache.Get(key)
-	if err == nil {
-		return val, nil
+	e, expired, err := cp.lookup(key)
+	if err == nil && !expired {
+		return e.Value, nil
 	}
 
-	// lookup key in the backing store.
-	val, err = cp.backingClient.Get(key)
+	// lookup key in the keys known to be
+	// missing in the backing store.
+	if err != nil && cp.negativeCache != nil {
+		if _, nerr := cp.negativeCache.Get(key); nerr == nil {
+			atomic.AddUint64(&cp.counters.negativeHits, 1)
+			return "", cache.ErrKeyNotFound
+		}
+	}
+
+	// lookup key in the backing store. concurrent
+	// misses for the key share a single load.
+	c, err := cp.share(ctx, key)
 	if err != nil {
 		return "", err
 	}
 
-	// add key to in-memory cache
-	cp.lruCache.Set(key, val)
-	return val, nil
+	// serve the expired key if the backing
+	// store fails, and drop it if the key was
+	// removed from the backing store.
+	if expired && c.err == cache.ErrKeyNotFound {
+		cp.lruCache.Delete(key)
+	} else if expired && c.err != nil {
+		atomic.AddUint64(&cp.counters.staleOnError, 1)
+		return e.Value, &cache.StaleError{Err: c.err}
+	}
+	if c.err != nil {
+		return "", c.err
+	}
+	return c.val, nil
+}
+
+// Stats returns the stats for the in-memory
+// cache and the calls to the backing store.
+func (cp *cacheProxy) Stats() Stats {
+	s := Stats{
+		Backend: cp.counters.stats(),
+	}
+	if sr, ok := cp.lruCache.(cache.StatsReporter); ok {
+		s.Cache = sr.Stats()
+	}
+	if br, ok := cp.backingClient.(BreakerStatsReporter); ok {
+		bs := br.BreakerStats()
+		s.Breaker = &bs
+	}
+	return s
+}
+
+// Delete removes the key from the in-memory cache,
+// and the negative cache. The backing store is left
+// untouched, so the next lookup for the key fetches
+// it again.
+func (cp *cacheProxy) Delete(key string) bool {
+	ok := cp.lruCache.Delete(key)
+	if cp.negativeCache != nil && cp.negativeCache.Delete(key) {
+		ok = true
+	}
+	return ok
+}
+
+// Flush removes all the keys from the in-memory
+// cache, and the negative cache.
+func (cp *cacheProxy) Flush() {
+	cp.lruCache.Flush()
+	if cp.negativeCache != nil {
+		cp.negativeCache.Flush()
+	}
+}
+
+// share loads the key from the backing store, sharing
+// the load with concurrent misses for the key. The load
+// is made with the context of the first caller, so it's
+// retried if that caller went away while the context of
+// the current caller is still alive.
+func (cp *cacheProxy) share(ctx context.Context, key string) (*call, error) {
+	for {
+		c, shared, err := cp.flights.doContext(ctx, key, func() (string, time.Duration, error) {
+			return cp.fetch(ctx, key)
+		})
+		if shared {
+			atomic.AddUint64(&cp.counters.coalesced, 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if shared && c.err == context.Canceled && ctx.Err() == nil {
+			continue
+		}
+		return c, nil
+	}
+}
+
+// Close waits for the background refreshes of the keys,
+// and stops new ones from starting. The in-memory cache
+// and the backing store are left open.
+func (cp *cacheProxy) Close() error {
+	cp.mu.Lock()
+	cp.closed = true
+	cp.mu.Unlock()
+
+	cp.refreshing.Wait()
+	return nil
+}
+
+// invalidate removes the keys modified in the backing store
+// from the in-memory cache and the negative cache. Every key
+// is removed if the keys are nil.
+func (cp *cacheProxy) invalidate(keys []string) {
+	cp.generations.invalidate(keys)
+	if keys == nil {
+		atomic.AddUint64(&cp.counters.invalidations, 1)
+		cp.Flush()
+		return
+	}
+	atomic.AddUint64(&cp.counters.invalidations, uint64(len(keys)))
+	for _, key := range keys {
+		cp.Delete(key)
+	}
+}
+
+// lookup looks up the key in the in-memory cache. Keys
+// past their ttl, but within the stale window, are served
+// while they're refreshed in the background. Keys past
+// the stale window, but within the grace period, are
+// reported as expired, so that they're only served if
+// the backing store fails.
+func (cp *cacheProxy) lookup(key string) (cache.Entry, bool, error) {
+	eg, ok := cp.lruCache.(cache.EntryGetter)
+	if !ok || cp.retention <= 0 {
+		val, err := cp.lruCache.Get(key)
+		return cache.Entry{Value: val}, false, err
+	}
+
+	e, err := eg.GetEntry(key)
+	if err != nil || e.Expiry.IsZero() {
+		return e, false, err
+	}
+
+	now := time.Now()
+	fresh := e.Expiry.Add(-cp.retention)
+	switch {
+	case !now.After(fresh):
+		return e, false, nil
+	case !now.After(fresh.Add(cp.staleWindow)):
+		atomic.AddUint64(&cp.counters.stale, 1)
+		cp.refresh(key)
+		return e, false, nil
+	}
+	return e, true, nil
+}
+
+// refresh fetches the key from the backing store in
+// the background, unless a load for it is in flight or
+// the proxy is closed. A key which was removed from the
+// backing store is removed from the in-memory cache.
+func (cp *cacheProxy) refresh(key string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.closed {
+		return
+	}
+
+	cp.refreshing.Add(1)
+	started := cp.flights.doAsync(key, func() (string, time.Duration, error) {
+		defer cp.refreshing.Done()
+		val, ttl, err := cp.fetch(context.Background(), key)
+		if err == cache.ErrKeyNotFound {
+			cp.lruCache.Delete(key)
+		}
+		return val, ttl, err
+	})
+	if !started {
+		cp.refreshing.Done()
+		return
+	}
+	atomic.AddUint64(&cp.counters.refreshes, 1)
+}
+
+// fetch loads the key from the backing store,
+// and adds it to the in-memory cache. A missing
+// key is added to the negative cache. Neither is
+// done if the key was invalidated during the load.
+func (cp *cacheProxy) fetch(ctx context.Context, key string) (string, time.Duration, error) {
+	gen := cp.generations.start(key)
+	val, ttl, err := cp.load(ctx, key)
+	cp.generations.finish(key, gen, func() {
+		switch {
+		case err == cache.ErrKeyNotFound && cp.negativeCache != nil:
+			cp.negativeCache.Set(key, "")
+		case err == nil:
+			// add key to in-memory cache
+			cp.set(key, val, ttl)
+		}
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return val, ttl, nil
+}
+
+// load fetches the value for the key from the backing
+// store, along with its remaining ttl if the store reports it.
+// Calls failed by a circuit breaker, or canceled by the
+// caller, aren't recorded.
+func (cp *cacheProxy) load(ctx context.Context, key string) (val string, ttl time.Duration, err error) {
+	if cp.backendTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cp.backendTimeout)
+		defer cancel()
+	}
+	defer func(start time.Time) {
+		if err != ErrCircuitOpen && err != context.Canceled {
+			cp.counters.record(time.Since(start), err)
+		}
+	}(time.Now())
+
+	switch g := cp.backingClient.(type) {
+	case cache.ContextTTLGetter:
+		return g.GetWithTTLContext(ctx, key)
+	case cache.TTLGetter:
+		return g.GetWithTTL(key)
+	case cache.ContextGetter:
+		val, err = g.GetContext(ctx, key)
+		return val, 0, err
+	}
+	val, err = cp.backingClient.Get(key)
+	return val, 0, err
+}
+
+// set adds the key to the in-memory cache. The ttl for
+// the key is capped at the expiry in the backing store,
+// given by storeTTL, if the cache supports a ttl per key.
+// The key is kept for the retention after its ttl.
+func (cp *cacheProxy) set(key, val string, storeTTL time.Duration) {
+	ts, ok := cp.lruCache.(cache.TTLSetter)
+	if !ok {
+		cp.lruCache.Set(key, val)
+		return
+	}
+
+	ttl := cp.ttl
+	if cp.keyTTL != nil {
+		if d := cp.keyTTL(key); d > 0 {
+			ttl = d
+		}
+	}
+	if storeTTL > 0 && (ttl <= 0 || storeTTL < ttl) {
+		ttl = storeTTL
+	}
+	if ttl > 0 {
+		ttl += cp.retention
+	}
+	ts.SetWithTTL(key, val, ttl)
 }
@@ -1,8 +1,13 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/vikramsk/rediproxy/pkg/cache"
 	"github.com/vikramsk/rediproxy/pkg/internal/mocks"
@@ -11,6 +16,9 @@ import (
 type mockCacher struct {
 	*mocks.Getter
 	*mocks.Setter
+	*mocks.Deleter
+	*mocks.Flusher
+	*mocks.Closer
 }
 
 func cacheHit(key string) (string, error) {
@@ -21,10 +29,26 @@ func cacheMiss(key string) (string, error) {
 	return "", cache.ErrKeyNotFound
 }
 
+func internalError(key string) (string, error) {
+	return "", errors.New("internal error")
+}
+
 func cacheSet(key, value string) {
 	// no op
 }
 
+func cacheDelete(key string) bool {
+	return true
+}
+
+func cacheFlush() {
+	// no op
+}
+
+func cacheClose() error {
+	return nil
+}
+
 func getBackingLRUMocks(
 	backingGet func(string) (string, error),
 	lruGet func(string) (string, error),
@@ -41,6 +65,15 @@ func getBackingLRUMocks(
 		Setter: &mocks.Setter{
 			SetFn: lruSet,
 		},
+		Deleter: &mocks.Deleter{
+			DeleteFn: cacheDelete,
+		},
+		Flusher: &mocks.Flusher{
+			FlushFn: cacheFlush,
+		},
+		Closer: &mocks.Closer{
+			CloseFn: cacheClose,
+		},
 	}
 	return mBacking, mLRU
 }
@@ -72,3 +105,616 @@ func TestInMemoryCacheMiss_BackingMiss(t *testing.T) {
 		t.Fatalf("expected the function to return after failing to retrieve data from backing store")
 	}
 }
+
+type mockTTLGetter struct {
+	*mocks.Getter
+	*mocks.TTLGetter
+}
+
+type mockTTLCacher struct {
+	*mockCacher
+	*mocks.TTLSetter
+}
+
+func TestInMemoryCacheMiss_KeyTTL(t *testing.T) {
+	mBacking, mLRU := getBackingLRUMocks(cacheHit, cacheMiss, cacheSet)
+
+	var ttl time.Duration
+	mTTL := &mockTTLCacher{
+		mockCacher: mLRU,
+		TTLSetter: &mocks.TTLSetter{
+			SetWithTTLFn: func(key, value string, d time.Duration) {
+				ttl = d
+			},
+		},
+	}
+
+	pc := NewCacheProxy(mBacking, mTTL, WithKeyTTL(func(key string) time.Duration {
+		return time.Minute
+	}))
+	_, err := pc.Get("key")
+	if err != nil || !mTTL.SetWithTTLFnInvoked || mLRU.SetFnInvoked || ttl != time.Minute {
+		t.Fatalf("expected the data to be set in the lru cache with the ttl for the key")
+	}
+}
+
+func TestInMemoryCacheMiss_BackingTTL(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		backingTTL time.Duration
+		expected   time.Duration
+	}{
+		{"backing expiry before the default ttl caps the ttl", time.Second, time.Second},
+		{"backing expiry after the default ttl is ignored", time.Hour * 2, time.Hour},
+		{"backing key without an expiry uses the default ttl", 0, time.Hour},
+	}
+
+	for _, s := range scenarios {
+		var ttl time.Duration
+		backingTTL := s.backingTTL
+		mBacking := &mockTTLGetter{
+			Getter: &mocks.Getter{
+				GetFn: cacheHit,
+			},
+			TTLGetter: &mocks.TTLGetter{
+				GetWithTTLFn: func(key string) (string, time.Duration, error) {
+					return "value", backingTTL, nil
+				},
+			},
+		}
+		_, mLRU := getBackingLRUMocks(cacheHit, cacheMiss, cacheSet)
+		mTTL := &mockTTLCacher{
+			mockCacher: mLRU,
+			TTLSetter: &mocks.TTLSetter{
+				SetWithTTLFn: func(key, value string, d time.Duration) {
+					ttl = d
+				},
+			},
+		}
+
+		pc := NewCacheProxy(mBacking, mTTL, WithTTL(time.Hour))
+		_, err := pc.Get("key")
+		if err != nil || !mBacking.GetWithTTLFnInvoked || mBacking.GetFnInvoked || ttl != s.expected {
+			t.Errorf("backing ttl test failed for: %s, expected: %s, received: %s", s.name, s.expected, ttl)
+		}
+	}
+}
+
+// blockingGetter is a backing store which blocks
+// loads until it is released.
+type blockingGetter struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (bg *blockingGetter) Get(key string) (string, error) {
+	atomic.AddInt32(&bg.calls, 1)
+	<-bg.release
+	return "value", nil
+}
+
+func TestConcurrentMisses_Coalesced(t *testing.T) {
+	backing := &blockingGetter{release: make(chan struct{})}
+	lc := cache.NewLRUCache(100, time.Hour)
+	defer lc.Close()
+	pc := NewCacheProxy(backing, lc)
+	cp := pc.(*cacheProxy)
+
+	var wg, joining sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		joining.Add(1)
+		go func() {
+			defer wg.Done()
+			joining.Done()
+			val, err := pc.Get("key")
+			if val != "value" || err != nil {
+				t.Errorf("expected all callers to receive the loaded value")
+			}
+		}()
+	}
+
+	// release the load once every caller has joined it.
+	joining.Wait()
+	time.Sleep(time.Millisecond * 50)
+	close(backing.release)
+	wg.Wait()
+
+	stats := cp.Stats().Backend
+	if backing.calls != 1 || stats.Calls != 1 || stats.Coalesced != 9 {
+		t.Fatalf("expected concurrent misses to share a single load, stats: %+v", stats)
+	}
+}
+
+func TestDeleteFlush(t *testing.T) {
+	mBacking, mLRU := getBackingLRUMocks(cacheHit, cacheHit, cacheSet)
+	pc := NewCacheProxy(mBacking, mLRU)
+
+	if !pc.(cache.Deleter).Delete("key") || !mLRU.DeleteFnInvoked {
+		t.Fatalf("expected the key to be deleted from the lru cache")
+	}
+
+	pc.(cache.Flusher).Flush()
+	if !mLRU.FlushFnInvoked {
+		t.Fatalf("expected the lru cache to be flushed")
+	}
+}
+
+func TestStats(t *testing.T) {
+	mBacking, _ := getBackingLRUMocks(cacheMiss, cacheMiss, cacheSet)
+	lc := cache.NewLRUCache(100, time.Hour)
+	defer lc.Close()
+	pc := NewCacheProxy(mBacking, lc)
+	pc.Get("key")
+
+	mBacking.GetFn = internalError
+	pc.Get("key")
+
+	mBacking.GetFn = cacheHit
+	pc.Get("key")
+	pc.Get("key")
+
+	s := pc.(StatsReporter).Stats()
+	if s.Backend.Calls != 3 || s.Backend.Errors != 1 {
+		t.Fatalf("expected backend calls and errors to be counted, stats: %+v", s.Backend)
+	}
+	if s.Cache.Hits != 1 || s.Cache.Misses != 3 || s.Cache.Items != 1 {
+		t.Fatalf("expected the stats for the in-memory cache, stats: %+v", s.Cache)
+	}
+}
+
+// mockEntryCacher is an in-memory cache
+// which reports the expiry of the keys.
+type mockEntryCacher struct {
+	*mockCacher
+	*mocks.EntryGetter
+	*mocks.TTLSetter
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	scenarios := []struct {
+		name      string
+		expiresIn time.Duration
+		stale     bool
+	}{
+		{
+			name:      "fresh key",
+			expiresIn: time.Minute * 2,
+			stale:     false,
+		},
+		{
+			name:      "stale key",
+			expiresIn: time.Second * 30,
+			stale:     true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			backing := &blockingGetter{release: make(chan struct{})}
+			_, mLRU := getBackingLRUMocks(cacheMiss, cacheMiss, cacheSet)
+			set := make(chan time.Duration, 1)
+			lc := &mockEntryCacher{
+				mockCacher: mLRU,
+				EntryGetter: &mocks.EntryGetter{
+					GetEntryFn: func(key string) (cache.Entry, error) {
+						return cache.Entry{Value: "cached", Expiry: time.Now().Add(s.expiresIn)}, nil
+					},
+				},
+				TTLSetter: &mocks.TTLSetter{
+					SetWithTTLFn: func(key, value string, ttl time.Duration) {
+						set <- ttl
+					},
+				},
+			}
+			pc := NewCacheProxy(backing, lc,
+				WithTTL(time.Hour),
+				WithStaleWhileRevalidate(time.Minute),
+			)
+			cp := pc.(*cacheProxy)
+
+			// stale lookups don't wait for
+			// the refresh of the key.
+			for i := 0; i < 10; i++ {
+				if val, err := pc.Get("key"); val != "cached" || err != nil {
+					t.Fatalf("expected the cached value to be served")
+				}
+			}
+			close(backing.release)
+
+			stats := cp.Stats().Backend
+			if !s.stale {
+				if stats.Stale != 0 || stats.Refreshes != 0 {
+					t.Fatalf("expected fresh keys not to be refreshed, stats: %+v", stats)
+				}
+				return
+			}
+
+			// the refreshed key is kept for the
+			// stale window after its ttl.
+			if ttl := <-set; ttl != time.Hour+time.Minute {
+				t.Fatalf("expected the refreshed key to be set with the stale window, received: %v", ttl)
+			}
+			stats = cp.Stats().Backend
+			if backing.calls != 1 || stats.Stale != 10 || stats.Refreshes != 1 {
+				t.Fatalf("expected stale lookups to share a single refresh, stats: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestStaleWhileRevalidate_Removed(t *testing.T) {
+	mBacking, mLRU := getBackingLRUMocks(cacheMiss, cacheMiss, cacheSet)
+	deleted := make(chan string, 1)
+	mLRU.DeleteFn = func(key string) bool {
+		deleted <- key
+		return true
+	}
+	lc := &mockEntryCacher{
+		mockCacher: mLRU,
+		EntryGetter: &mocks.EntryGetter{
+			GetEntryFn: func(key string) (cache.Entry, error) {
+				return cache.Entry{Value: "cached", Expiry: time.Now().Add(time.Second * 30)}, nil
+			},
+		},
+		TTLSetter: &mocks.TTLSetter{},
+	}
+	pc := NewCacheProxy(mBacking, lc,
+		WithTTL(time.Hour),
+		WithStaleWhileRevalidate(time.Minute),
+	)
+
+	if val, err := pc.Get("key"); val != "cached" || err != nil {
+		t.Fatalf("expected the cached value to be served")
+	}
+	if key := <-deleted; key != "key" {
+		t.Fatalf("expected the key removed from the backing store to be deleted")
+	}
+}
+
+func TestClose_WaitsForRefresh(t *testing.T) {
+	backing := &blockingGetter{release: make(chan struct{})}
+	_, mLRU := getBackingLRUMocks(cacheMiss, cacheMiss, cacheSet)
+	lc := &mockEntryCacher{
+		mockCacher: mLRU,
+		EntryGetter: &mocks.EntryGetter{
+			GetEntryFn: func(key string) (cache.Entry, error) {
+				return cache.Entry{Value: "cached", Expiry: time.Now().Add(time.Second * 30)}, nil
+			},
+		},
+		TTLSetter: &mocks.TTLSetter{
+			SetWithTTLFn: func(key, value string, ttl time.Duration) {},
+		},
+	}
+	pc := NewCacheProxy(backing, lc,
+		WithTTL(time.Hour),
+		WithStaleWhileRevalidate(time.Minute),
+	)
+	cp := pc.(*cacheProxy)
+	pc.Get("key")
+
+	closed := make(chan struct{})
+	go func() {
+		cp.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatalf("expected close to wait for the refresh in flight")
+	case <-time.After(time.Millisecond * 50):
+	}
+	close(backing.release)
+	<-closed
+
+	// stale keys aren't refreshed once
+	// the proxy is closed.
+	pc.Get("key")
+	if s := cp.Stats().Backend; s.Refreshes != 1 || backing.calls != 1 {
+		t.Fatalf("expected no refresh after close, stats: %+v", s)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	scenarios := []struct {
+		name          string
+		backingGet    func(string) (string, error)
+		expectedVal   string
+		expectedStale bool
+		deleted       bool
+	}{
+		{
+			name:        "backing hit should return the fetched value",
+			backingGet:  cacheHit,
+			expectedVal: "value",
+		},
+		{
+			name:          "backing error should return the stale value",
+			backingGet:    internalError,
+			expectedVal:   "cached",
+			expectedStale: true,
+		},
+		{
+			name:        "backing miss should remove the key",
+			backingGet:  cacheMiss,
+			expectedVal: "",
+			deleted:     true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			mBacking, mLRU := getBackingLRUMocks(s.backingGet, cacheMiss, cacheSet)
+			var ttl time.Duration
+			lc := &mockEntryCacher{
+				mockCacher: mLRU,
+				EntryGetter: &mocks.EntryGetter{
+					// the key is past its ttl, and
+					// the stale window.
+					GetEntryFn: func(key string) (cache.Entry, error) {
+						return cache.Entry{Value: "cached", Expiry: time.Now().Add(time.Minute * 5)}, nil
+					},
+				},
+				TTLSetter: &mocks.TTLSetter{
+					SetWithTTLFn: func(key, value string, d time.Duration) {
+						ttl = d
+					},
+				},
+			}
+			pc := NewCacheProxy(mBacking, lc,
+				WithTTL(time.Hour),
+				WithStaleWhileRevalidate(time.Minute),
+				WithStaleIfError(time.Minute*10),
+			)
+
+			val, err := pc.Get("key")
+			se, stale := err.(*cache.StaleError)
+			if val != s.expectedVal || stale != s.expectedStale {
+				t.Fatalf("expected value: %q, stale: %v, received: %q, err: %v", s.expectedVal, s.expectedStale, val, err)
+			}
+			if stale && se.Err.Error() != "internal error" {
+				t.Fatalf("expected the stale error to wrap the backing error, received: %v", se.Err)
+			}
+			if mLRU.DeleteFnInvoked != s.deleted {
+				t.Fatalf("expected deleted: %v, received: %v", s.deleted, mLRU.DeleteFnInvoked)
+			}
+
+			// the fetched key is kept for the longer
+			// of the stale window and grace period.
+			if mLRU.SetFnInvoked || (ttl != 0 && ttl != time.Hour+time.Minute*10) {
+				t.Fatalf("expected the key to be set with the grace period, received: %v", ttl)
+			}
+
+			stats := pc.(StatsReporter).Stats().Backend
+			if stale != (stats.StaleOnError == 1) || stats.Refreshes != 0 {
+				t.Fatalf("expected stale lookups on errors to be counted, stats: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	mBacking, _ := getBackingLRUMocks(cacheMiss, cacheMiss, cacheSet)
+	backingCalls := 0
+	mBacking.GetFn = func(key string) (string, error) {
+		backingCalls++
+		if key == "empty" {
+			return "", nil
+		}
+		return "", cache.ErrKeyNotFound
+	}
+	lc := cache.NewLRUCache(100, time.Hour)
+	defer lc.Close()
+	nc := cache.NewLRUCache(100, time.Minute)
+	defer nc.Close()
+	pc := NewCacheProxy(mBacking, lc,
+		WithNegativeCache(nc),
+	)
+
+	for i := 0; i < 3; i++ {
+		if _, err := pc.Get("missing"); err != cache.ErrKeyNotFound {
+			t.Fatalf("expected a missing key to return key not found, received: %v", err)
+		}
+		if val, err := pc.Get("empty"); val != "" || err != nil {
+			t.Fatalf("expected an empty value not to be confused with a missing key, received: %v", err)
+		}
+	}
+
+	s := pc.(StatsReporter).Stats()
+	if backingCalls != 2 || s.Backend.NegativeHits != 2 {
+		t.Fatalf("expected missing keys to be served from the negative cache, stats: %+v", s.Backend)
+	}
+
+	// deleting the key forgets that it's missing.
+	if !pc.(cache.Deleter).Delete("missing") {
+		t.Fatalf("expected the key to be deleted from the negative cache")
+	}
+	pc.Get("missing")
+	if backingCalls != 3 {
+		t.Fatalf("expected a deleted key to be fetched again")
+	}
+}
+
+// mockContextGetter is a backing
+// store which accepts a context.
+type mockContextGetter struct {
+	*mocks.Getter
+	*mocks.ContextGetter
+}
+
+// waitDone blocks the load until
+// the context is done.
+func waitDone(ctx context.Context, key string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestBackendTimeout(t *testing.T) {
+	mBacking := &mockContextGetter{
+		Getter:        &mocks.Getter{GetFn: cacheHit},
+		ContextGetter: &mocks.ContextGetter{GetContextFn: waitDone},
+	}
+	lc := cache.NewLRUCache(100, time.Hour)
+	defer lc.Close()
+	pc := NewCacheProxy(mBacking, lc, WithBackendTimeout(time.Millisecond*10))
+
+	if _, err := pc.Get("key"); err != context.DeadlineExceeded {
+		t.Fatalf("expected the load to time out, received: %v", err)
+	}
+	if mBacking.GetFnInvoked || !mBacking.GetContextFnInvoked {
+		t.Fatalf("expected the context to be passed on to the backing store")
+	}
+	if s := pc.(StatsReporter).Stats().Backend; s.Calls != 1 || s.Errors != 1 {
+		t.Fatalf("expected the timed out load to be recorded as an error, stats: %+v", s)
+	}
+}
+
+func TestGetContext_LeaderCanceled(t *testing.T) {
+	var calls int32
+	mBacking := &mockContextGetter{
+		Getter: &mocks.Getter{GetFn: cacheHit},
+		ContextGetter: &mocks.ContextGetter{GetContextFn: func(ctx context.Context, key string) (string, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return waitDone(ctx, key)
+			}
+			return "value", nil
+		}},
+	}
+	lc := cache.NewLRUCache(100, time.Hour)
+	defer lc.Close()
+	pc := NewCacheProxy(mBacking, lc)
+	cp := pc.(*cacheProxy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := cp.GetContext(ctx, "key")
+		leader <- err
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	follower := make(chan string)
+	go func() {
+		val, _ := cp.GetContext(context.Background(), "key")
+		follower <- val
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	// the follower loads the key again once
+	// the caller which started the load leaves.
+	cancel()
+	if err := <-leader; err != context.Canceled {
+		t.Fatalf("expected the canceled caller to fail, received: %v", err)
+	}
+	if val := <-follower; val != "value" || calls != 2 {
+		t.Fatalf("expected the follower to retry the load, received: %q", val)
+	}
+	if s := cp.Stats().Backend; s.Calls != 1 || s.Errors != 0 {
+		t.Fatalf("expected the canceled load not to be recorded, stats: %+v", s)
+	}
+}
+
+// mockNotifier is a backing store
+// which reports the modified keys.
+type mockNotifier struct {
+	*mocks.Getter
+	invalidate InvalidateFunc
+}
+
+func (mn *mockNotifier) OnInvalidate(fn InvalidateFunc) {
+	mn.invalidate = fn
+}
+
+func TestInvalidation(t *testing.T) {
+	mBacking := &mockNotifier{Getter: &mocks.Getter{GetFn: cacheHit}}
+	lc := cache.NewLRUCache(100, time.Hour)
+	defer lc.Close()
+	pc := NewCacheProxy(NewCircuitBreaker(mBacking), lc)
+
+	pc.Get("key1")
+	pc.Get("key2")
+	if mBacking.invalidate == nil {
+		t.Fatalf("expected the proxy to be notified of the modified keys")
+	}
+
+	mBacking.invalidate([]string{"key1"})
+	if _, err := lc.Get("key1"); err != cache.ErrKeyNotFound {
+		t.Fatalf("expected the modified key to be removed")
+	}
+	if _, err := lc.Get("key2"); err != nil {
+		t.Fatalf("expected the other keys to be kept")
+	}
+
+	mBacking.invalidate(nil)
+	if _, err := lc.Get("key2"); err != cache.ErrKeyNotFound {
+		t.Fatalf("expected every key to be removed")
+	}
+	if s := pc.(StatsReporter).Stats().Backend; s.Invalidations != 2 {
+		t.Fatalf("expected the invalidations to be counted, stats: %+v", s)
+	}
+}
+
+func TestInvalidation_DuringLoad(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		key         string
+		invalidated []string
+		cached      bool
+	}{
+		{
+			name:        "invalidated key is dropped",
+			key:         "key1",
+			invalidated: []string{"key1"},
+		},
+		{
+			name:        "flushed key is dropped",
+			key:         "key1",
+			invalidated: nil,
+		},
+		{
+			name:        "missing invalidated key is dropped",
+			key:         "missing",
+			invalidated: []string{"missing"},
+		},
+		{
+			name:        "other invalidated key is cached",
+			key:         "key1",
+			invalidated: []string{"key2"},
+			cached:      true,
+		},
+	}
+
+	for _, s := range scenarios {
+		mBacking := &mockNotifier{Getter: &mocks.Getter{}}
+		lc := cache.NewLRUCache(100, time.Hour)
+		nc := cache.NewLRUCache(100, time.Hour)
+		pc := NewCacheProxy(mBacking, lc, WithNegativeCache(nc))
+
+		// the key is invalidated after the backing
+		// store replied, before it's cached.
+		mBacking.GetFn = func(key string) (string, error) {
+			defer mBacking.invalidate(s.invalidated)
+			if key == "missing" {
+				return cacheMiss(key)
+			}
+			return cacheHit(key)
+		}
+		pc.Get(s.key)
+
+		_, err := lc.Get(s.key)
+		_, nerr := nc.Get(s.key)
+		if cached := err == nil || nerr == nil; cached != s.cached {
+			t.Errorf("unexpected caching for: %s, expected cached: %v", s.name, s.cached)
+		}
+
+		// the next load isn't affected.
+		mBacking.GetFn = cacheHit
+		pc.Get(s.key)
+		if _, err := lc.Get(s.key); err != nil {
+			t.Errorf("expected the next load to be cached for: %s", s.name)
+		}
+		lc.Close()
+		nc.Close()
+	}
+}
@@ -1,27 +1,84 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/go-redis/redis"
 	"github.com/vikramsk/rediproxy/pkg/cache"
+	"github.com/vikramsk/rediproxy/pkg/metrics"
 )
 
+var (
+	redisDuration = metrics.NewHistogramVec(
+		"rediproxy_redis_request_duration_seconds",
+		"Latency of the calls to redis in seconds by operation.",
+		metrics.DefBuckets,
+		"operation",
+	)
+	redisErrors = metrics.NewCounterVec(
+		"rediproxy_redis_errors_total",
+		"Number of failed calls to redis by operation. Missing keys aren't counted as errors.",
+		"operation",
+	)
+)
+
+func init() {
+	metrics.MustRegister(redisDuration, redisErrors)
+}
+
+// observe records the latency of a call to redis,
+// and counts it as failed if it returned an error
+// other than cache.ErrKeyNotFound.
+func observe(op string, start time.Time, err error) {
+	redisDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil && err != cache.ErrKeyNotFound {
+		redisErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// ensure that the redis client reports
+// the expiry of the keys.
+var _ = cache.TTLGetter(&redisClient{})
+var _ = cache.ContextGetter(&redisClient{})
+var _ = cache.ContextTTLGetter(&redisClient{})
+var _ = io.Closer(&redisClient{})
+
 type redisClient struct {
-	client *redis.Client
+	client redisCmdable
+}
+
+// redisCmdable is the subset of the go-redis
+// clients used by the redis client. It's
+// implemented by the single node, cluster
+// and ring clients.
+type redisCmdable interface {
+	redis.Cmdable
+	Process(cmd redis.Cmder) error
+	Close() error
 }
 
 // NewRedisClient initializes a wrapper around the
-// go-redis/redis client.
+// go-redis/redis client. The address is either host:port,
+// or a redis:// or rediss:// URL.
 func NewRedisClient(addr string) (cache.Getter, error) {
-	redisdb := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: "",
-		DB:       0,
-	})
+	return NewRedisClientWithOptions(RedisOptions{Addr: addr})
+}
 
-	_, err := redisdb.Ping().Result()
+// NewRedisClientWithOptions initializes a wrapper around
+// the go-redis/redis client, configured with the options.
+func NewRedisClientWithOptions(o RedisOptions) (cache.Getter, error) {
+	opt, err := o.clientOptions()
 	if err != nil {
+		return nil, err
+	}
+	redisdb := redis.NewClient(opt)
+
+	_, err = redisdb.Ping().Result()
+	if err != nil {
+		redisdb.Close()
 		return nil, fmt.Errorf("service: could not initialize redis client. err: %v", err)
 	}
 
@@ -31,7 +88,21 @@ func NewRedisClient(addr string) (cache.Getter, error) {
 // Get calls the underlying redis instance to fetch the
 // data for the given key.
 func (rc *redisClient) Get(key string) (string, error) {
-	cmd := rc.client.Get(key)
+	return rc.GetContext(context.Background(), key)
+}
+
+// GetContext calls the underlying redis instance to fetch
+// the data for the given key. It returns the error of the
+// context if the context is done before the call returns.
+func (rc *redisClient) GetContext(ctx context.Context, key string) (val string, err error) {
+	defer func(start time.Time) {
+		observe("get", start, err)
+	}(time.Now())
+
+	cmd := redis.NewStringCmd("get", key)
+	if err := rc.do(ctx, func(c redisCmdable) { c.Process(cmd) }); err != nil {
+		return "", err
+	}
 	if cmd.Err() != nil {
 		if cmd.Err() == redis.Nil {
 			return "", cache.ErrKeyNotFound
@@ -41,3 +112,98 @@ func (rc *redisClient) Get(key string) (string, error) {
 
 	return cmd.Val(), nil
 }
+
+// GetWithTTL calls the underlying redis instance to fetch the
+// data and the remaining time to live for the given key. The
+// GET and PTTL commands are pipelined in a single round trip.
+// A ttl of 0 is returned for keys without an expiry.
+func (rc *redisClient) GetWithTTL(key string) (string, time.Duration, error) {
+	return rc.GetWithTTLContext(context.Background(), key)
+}
+
+// GetWithTTLContext is the context aware
+// counterpart of GetWithTTL.
+func (rc *redisClient) GetWithTTLContext(ctx context.Context, key string) (val string, ttl time.Duration, err error) {
+	defer func(start time.Time) {
+		observe("get_with_ttl", start, err)
+	}(time.Now())
+
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	err = rc.do(ctx, func(c redisCmdable) {
+		pipe := c.Pipeline()
+		get = pipe.Get(key)
+		pttl = pipe.PTTL(key)
+		pipe.Exec()
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	if get.Err() != nil {
+		if get.Err() == redis.Nil {
+			return "", 0, cache.ErrKeyNotFound
+		}
+		return "", 0, fmt.Errorf("service: error while reading key %s, err: %v", key, get.Err())
+	}
+	if pttl.Err() != nil {
+		return "", 0, fmt.Errorf("service: error while reading ttl for key %s, err: %v", key, pttl.Err())
+	}
+
+	// PTTL returns a negative value when
+	// the key doesn't have an expiry.
+	ttl = pttl.Val()
+	if ttl < 0 {
+		ttl = 0
+	}
+	return get.Val(), ttl, nil
+}
+
+// do runs fn with the client, until the context is done.
+// go-redis doesn't stop a call when its context is done,
+// so fn keeps running in its own goroutine after do returns
+// the error of the context, and holds a connection of the
+// pool until the read timeout. RedisOptions.CallTimeout
+// bounds that time. The results of fn must only be read if
+// do returns nil.
+func (rc *redisClient) do(ctx context.Context, fn func(c redisCmdable)) error {
+	if ctx.Done() == nil {
+		fn(rc.client)
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		fn(withContext(rc.client, ctx))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withContext returns a copy of the client
+// which carries the context to its calls.
+func withContext(c redisCmdable, ctx context.Context) redisCmdable {
+	switch c := c.(type) {
+	case *redis.Client:
+		return c.WithContext(ctx)
+	case *redis.ClusterClient:
+		return c.WithContext(ctx)
+	case *redis.Ring:
+		return c.WithContext(ctx)
+	}
+	return c
+}
+
+// Close closes the connections to the redis instance.
+func (rc *redisClient) Close() error {
+	return rc.client.Close()
+}
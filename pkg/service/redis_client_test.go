@@ -1,14 +1,20 @@
 package service
 
 import (
+	"context"
 	"flag"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/vikramsk/rediproxy/pkg/cache"
 )
 
 var redisURL = flag.String("redis-url", "localhost:6379", "URL for Redis")
 
-func init() {
+func TestMain(m *testing.M) {
 	flag.Parse()
+	os.Exit(m.Run())
 }
 
 func TestRedisConnection(t *testing.T) {
@@ -38,3 +44,75 @@ func TestRedisGet(t *testing.T) {
 		t.Fatalf("redis get failed")
 	}
 }
+
+func TestRedisGetWithTTL(t *testing.T) {
+	rc, err := NewRedisClient(*redisURL)
+	if err != nil {
+		t.Fatalf("expected client to be created")
+	}
+
+	// to setup repeatable tests
+	c := rc.(*redisClient)
+	c.client.Del("key", "expiringKey")
+	c.client.Set("key", "value", 0)
+	c.client.Set("expiringKey", "value", time.Minute)
+
+	val, ttl, err := c.GetWithTTL("key")
+	if err != nil || val != "value" || ttl != 0 {
+		t.Fatalf("expected key without expiry to have no ttl")
+	}
+
+	val, ttl, err = c.GetWithTTL("expiringKey")
+	if err != nil || val != "value" || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected key with expiry to report the remaining ttl")
+	}
+
+	_, _, err = c.GetWithTTL("missingKey")
+	if err != cache.ErrKeyNotFound {
+		t.Fatalf("expected missing key to return key not found")
+	}
+}
+
+func TestRedisGetContext(t *testing.T) {
+	rc, err := NewRedisClient(*redisURL)
+	if err != nil {
+		t.Fatalf("expected client to be created")
+	}
+
+	c := rc.(*redisClient)
+	c.client.Set("key", "value", 0)
+
+	val, err := c.GetContext(context.Background(), "key")
+	if err != nil || val != "value" {
+		t.Fatalf("redis get with context failed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = c.GetContext(ctx, "key"); err != context.Canceled {
+		t.Fatalf("expected a done context to fail the get, received: %v", err)
+	}
+	if _, _, err = c.GetWithTTLContext(ctx, "key"); err != context.Canceled {
+		t.Fatalf("expected a done context to fail the get with ttl, received: %v", err)
+	}
+}
+
+func TestRedisMetrics(t *testing.T) {
+	rc, err := NewRedisClient(*redisURL)
+	if err != nil {
+		t.Fatalf("expected client to be created")
+	}
+
+	before := redisErrors.WithLabelValues("get").Value()
+	rc.Get("missingKey")
+	if redisErrors.WithLabelValues("get").Value() != before {
+		t.Fatalf("expected missing keys to not be counted as errors")
+	}
+
+	// closing the client makes every call fail.
+	rc.(*redisClient).client.Close()
+	rc.Get("key")
+	if redisErrors.WithLabelValues("get").Value() != before+1 {
+		t.Fatalf("expected failed calls to be counted as errors")
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// respError is an error reply of redis.
+type respError string
+
+func (e respError) Error() string {
+	return "service: redis error: " + string(e)
+}
+
+// writeCommand writes the command to the
+// writer in the RESP protocol, and flushes it.
+func writeCommand(w *bufio.Writer, args ...string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return w.Flush()
+}
+
+// readReply reads a reply in the RESP2 protocol. Status
+// and bulk strings are returned as strings, integers as
+// int64, arrays as []interface{} and error replies as a
+// respError. Null bulk strings and arrays are nil.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("service: invalid redis reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return respError(line), nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		vals := make([]interface{}, n)
+		for i := range vals {
+			if vals[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	}
+	return nil, fmt.Errorf("service: unknown redis reply type: %q", kind)
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/vikramsk/rediproxy/pkg/cache"
+)
+
+// RingOptions configures a backend which shards the
+// keys across independent redis instances.
+type RingOptions struct {
+	// Shards maps the names of the shards to their
+	// host:port addresses. The names are hashed onto
+	// the ring, so the keys keep their shard when the
+	// address of a shard changes.
+	Shards map[string]string
+
+	// VirtualNodes is the number of points on the
+	// ring for each shard. It defaults to 100.
+	VirtualNodes int
+
+	// HealthCheckInterval is the time between the
+	// pings of each shard. It defaults to 500ms.
+	HealthCheckInterval time.Duration
+
+	// RedisOptions configures the connections to the
+	// shards. The address is unused, unless it's a URL
+	// with the credentials or the database. TLS isn't
+	// supported by go-redis for the shards of a ring.
+	RedisOptions
+}
+
+// NewRingClient initializes a backend which maps each key
+// to a shard with a consistent hash ring, using the crc32
+// of the key, or of its {hash tag} if it has one. A shard
+// is removed from the ring after 3 failed health checks,
+// and added back once it responds.
+func NewRingClient(o RingOptions) (cache.Getter, error) {
+	if len(o.Shards) == 0 {
+		return nil, errors.New("service: ring requires the addresses of the shards")
+	}
+	opt, err := o.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	if opt.TLSConfig != nil {
+		return nil, errors.New("service: redis tls isn't supported with a ring")
+	}
+
+	ring := redis.NewRing(&redis.RingOptions{
+		Addrs:              o.Shards,
+		HashReplicas:       o.VirtualNodes,
+		HeartbeatFrequency: o.HealthCheckInterval,
+		OnConnect:          opt.OnConnect,
+		Password:           opt.Password,
+		DB:                 opt.DB,
+		DialTimeout:        opt.DialTimeout,
+		ReadTimeout:        opt.ReadTimeout,
+		WriteTimeout:       opt.WriteTimeout,
+		PoolSize:           opt.PoolSize,
+		MinIdleConns:       opt.MinIdleConns,
+	})
+
+	// the shards which are down are removed by the
+	// health checks, so a single shard is enough.
+	var up int32
+	ring.ForEachShard(func(c *redis.Client) error {
+		if c.Ping().Err() == nil {
+			atomic.AddInt32(&up, 1)
+		}
+		return nil
+	})
+	if up == 0 {
+		ring.Close()
+		return nil, fmt.Errorf("service: could not initialize redis ring client, all %d shards are down", len(o.Shards))
+	}
+
+	return &redisClient{ring}, nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestNewRingClient_Invalid(t *testing.T) {
+	scenarios := []struct {
+		name string
+		opts RingOptions
+	}{
+		{
+			name: "no shards",
+			opts: RingOptions{},
+		},
+		{
+			name: "tls",
+			opts: RingOptions{Shards: map[string]string{"a": *redisURL}, RedisOptions: RedisOptions{TLS: true}},
+		},
+		{
+			name: "all shards down",
+			opts: RingOptions{Shards: map[string]string{"a": "127.0.0.1:1", "b": "127.0.0.1:2"}},
+		},
+	}
+
+	for _, s := range scenarios {
+		if rc, err := NewRingClient(s.opts); err == nil || rc != nil {
+			t.Errorf("expected a failure in client creation for: %s", s.name)
+		}
+	}
+}
+
+func TestRingClient_UnhealthyShard(t *testing.T) {
+	rc, err := NewRingClient(RingOptions{
+		Shards: map[string]string{
+			"up":   *redisURL,
+			"down": "127.0.0.1:1",
+		},
+		HealthCheckInterval: time.Millisecond * 10,
+	})
+	if err != nil {
+		t.Fatalf("expected client to be created with a single shard up, err: %v", err)
+	}
+	c := rc.(*redisClient)
+	defer c.Close()
+
+	// the shard which is down is removed
+	// from the ring by the health checks.
+	ring := c.client.(*redis.Ring)
+	deadline := time.Now().Add(time.Second * 5)
+	for ring.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the shard which is down to be removed")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// the keys are all mapped to the shard which is up.
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("ringKey%d", i)
+		c.client.Set(key, "value", 0)
+		if val, err := rc.Get(key); err != nil || val != "value" {
+			t.Fatalf("expected key %s to be read from the shard which is up, err: %v", key, err)
+		}
+	}
+}
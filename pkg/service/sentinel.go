@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/vikramsk/rediproxy/pkg/cache"
+)
+
+// replicaRefreshInterval is the time between
+// the lookups of the replicas of the master.
+const replicaRefreshInterval = time.Second * 10
+
+// SentinelOptions configures a backend whose
+// master is discovered through Redis Sentinel.
+type SentinelOptions struct {
+	// MasterName is the name of the master
+	// monitored by the sentinels.
+	MasterName string
+
+	// SentinelAddrs are the host:port addresses of the
+	// sentinels. Other sentinels of the master are
+	// discovered from the first one which responds.
+	SentinelAddrs []string
+
+	// ReadFromReplicas sends the lookups to the replicas
+	// of the master, which may lag behind it. Lookups fall
+	// back to the master if no replica is reachable.
+	ReadFromReplicas bool
+
+	// RedisOptions configures the connections to the
+	// master and the replicas. The address is unused,
+	// unless it's a URL with the credentials or the
+	// database. TLS isn't supported by go-redis for
+	// sentinel-managed instances.
+	RedisOptions
+}
+
+// ensure that the sentinel client reports
+// the expiry of the keys.
+var _ = cache.TTLGetter(&sentinelClient{})
+var _ = cache.ContextGetter(&sentinelClient{})
+var _ = cache.ContextTTLGetter(&sentinelClient{})
+var _ = io.Closer(&sentinelClient{})
+
+// sentinelClient sends lookups to the
+// replicas of a sentinel-managed master.
+type sentinelClient struct {
+	// next is the index of the replica
+	// for the next lookup.
+	next uint64
+
+	master *redisClient
+
+	// opt is the template for the
+	// connections to the replicas.
+	opt *redis.Options
+
+	masterName    string
+	sentinelAddrs []string
+
+	// replicas are the clients for the
+	// replicas at the addresses.
+	mu       sync.RWMutex
+	replicas []*redisClient
+	addrs    []string
+
+	// done is closed once, by Close.
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewSentinelClient initializes a backend for the master
+// monitored by the sentinels, which follows it when it
+// fails over.
+func NewSentinelClient(o SentinelOptions) (cache.Getter, error) {
+	if o.MasterName == "" || len(o.SentinelAddrs) == 0 {
+		return nil, errors.New("service: sentinel requires a master name and the sentinel addresses")
+	}
+	opt, err := o.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	if opt.TLSConfig != nil {
+		return nil, errors.New("service: redis tls isn't supported with sentinel")
+	}
+
+	redisdb := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    o.MasterName,
+		SentinelAddrs: o.SentinelAddrs,
+		OnConnect:     opt.OnConnect,
+		Password:      opt.Password,
+		DB:            opt.DB,
+		DialTimeout:   opt.DialTimeout,
+		ReadTimeout:   opt.ReadTimeout,
+		WriteTimeout:  opt.WriteTimeout,
+		PoolSize:      opt.PoolSize,
+		MinIdleConns:  opt.MinIdleConns,
+	})
+	if _, err := redisdb.Ping().Result(); err != nil {
+		redisdb.Close()
+		return nil, fmt.Errorf("service: could not initialize redis sentinel client. err: %v", err)
+	}
+
+	rc := &redisClient{redisdb}
+	if !o.ReadFromReplicas {
+		return rc, nil
+	}
+
+	sc := &sentinelClient{
+		master:        rc,
+		opt:           opt,
+		masterName:    o.MasterName,
+		sentinelAddrs: o.SentinelAddrs,
+		done:          make(chan struct{}),
+	}
+	sc.refreshReplicas()
+	go sc.watchReplicas()
+	return sc, nil
+}
+
+// Get fetches the key from a replica.
+func (sc *sentinelClient) Get(key string) (string, error) {
+	return sc.GetContext(context.Background(), key)
+}
+
+// GetContext fetches the key from a replica, or from
+// the master if the replica fails or none is reachable.
+func (sc *sentinelClient) GetContext(ctx context.Context, key string) (string, error) {
+	if r := sc.replica(); r != nil {
+		val, err := r.GetContext(ctx, key)
+		if err == nil || err == cache.ErrKeyNotFound || ctx.Err() != nil {
+			return val, err
+		}
+	}
+	return sc.master.GetContext(ctx, key)
+}
+
+// GetWithTTL fetches the key along
+// with its expiry from a replica.
+func (sc *sentinelClient) GetWithTTL(key string) (string, time.Duration, error) {
+	return sc.GetWithTTLContext(context.Background(), key)
+}
+
+// GetWithTTLContext is the context aware
+// counterpart of GetWithTTL.
+func (sc *sentinelClient) GetWithTTLContext(ctx context.Context, key string) (string, time.Duration, error) {
+	if r := sc.replica(); r != nil {
+		val, ttl, err := r.GetWithTTLContext(ctx, key)
+		if err == nil || err == cache.ErrKeyNotFound || ctx.Err() != nil {
+			return val, ttl, err
+		}
+	}
+	return sc.master.GetWithTTLContext(ctx, key)
+}
+
+// Close stops the lookups of the replicas, and closes
+// the connections to the master and the replicas. It
+// is safe to call more than once.
+func (sc *sentinelClient) Close() (err error) {
+	sc.closeOnce.Do(func() {
+		close(sc.done)
+
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		err = sc.master.Close()
+		for _, r := range sc.replicas {
+			r.Close()
+		}
+		sc.replicas, sc.addrs = nil, nil
+	})
+	return err
+}
+
+// replica returns the replica for the next lookup,
+// or nil if no replica is reachable.
+func (sc *sentinelClient) replica() *redisClient {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	if len(sc.replicas) == 0 {
+		return nil
+	}
+	i := atomic.AddUint64(&sc.next, 1)
+	return sc.replicas[i%uint64(len(sc.replicas))]
+}
+
+// watchReplicas refreshes the replicas
+// until the client is closed.
+func (sc *sentinelClient) watchReplicas() {
+	ticker := time.NewTicker(replicaRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sc.refreshReplicas()
+		case <-sc.done:
+			return
+		}
+	}
+}
+
+// refreshReplicas looks up the replicas of the master
+// through the sentinels. The connections to the known
+// replicas are kept, and those to the replicas which are
+// gone are closed. The replicas are left as is if no
+// sentinel responds.
+func (sc *sentinelClient) refreshReplicas() {
+	addrs, err := sc.lookupReplicas()
+	if err != nil {
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	select {
+	case <-sc.done:
+		return
+	default:
+	}
+
+	known := make(map[string]*redisClient, len(sc.replicas))
+	for i, r := range sc.replicas {
+		known[sc.addrs[i]] = r
+	}
+	replicas := make([]*redisClient, 0, len(addrs))
+	for _, addr := range addrs {
+		if r, ok := known[addr]; ok {
+			replicas = append(replicas, r)
+			delete(known, addr)
+			continue
+		}
+		opt := *sc.opt
+		opt.Addr = addr
+		replicas = append(replicas, &redisClient{redis.NewClient(&opt)})
+	}
+	for _, r := range known {
+		r.Close()
+	}
+	sc.replicas, sc.addrs = replicas, addrs
+}
+
+// lookupReplicas returns the addresses of the replicas
+// of the master from the first sentinel which responds.
+func (sc *sentinelClient) lookupReplicas() ([]string, error) {
+	err := errors.New("service: no sentinel addresses")
+	for _, addr := range sc.sentinelAddrs {
+		sentinel := redis.NewSentinelClient(&redis.Options{
+			Addr:         addr,
+			DialTimeout:  sc.opt.DialTimeout,
+			ReadTimeout:  sc.opt.ReadTimeout,
+			WriteTimeout: sc.opt.WriteTimeout,
+		})
+		var reply interface{}
+		reply, err = sentinel.Do("sentinel", "slaves", sc.masterName).Result()
+		sentinel.Close()
+		if err == nil {
+			return parseReplicas(reply)
+		}
+	}
+	return nil, err
+}
+
+// parseReplicas parses the reply of SENTINEL SLAVES, which
+// is a list of the replicas as field value pairs. Replicas
+// which are down or disconnected from the master are skipped.
+func parseReplicas(reply interface{}) ([]string, error) {
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("service: unexpected sentinel reply: %v", reply)
+	}
+
+	var addrs []string
+	for _, item := range items {
+		vals, ok := item.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("service: unexpected sentinel reply: %v", item)
+		}
+		fields := make(map[string]string, len(vals)/2)
+		for i := 0; i+1 < len(vals); i += 2 {
+			k, _ := vals[i].(string)
+			v, _ := vals[i+1].(string)
+			fields[k] = v
+		}
+
+		flags := strings.Split(fields["flags"], ",")
+		if contains(flags, "s_down") || contains(flags, "o_down") || contains(flags, "disconnected") ||
+			fields["master-link-status"] != "ok" {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(fields["ip"], fields["port"]))
+	}
+	return addrs, nil
+}
+
+// contains reports if the value is in the list.
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/vikramsk/rediproxy/pkg/cache"
+)
+
+var (
+	sentinelAddr   = flag.String("sentinel-addr", "", "URL for a Redis Sentinel. the sentinel tests are skipped if it's not set")
+	sentinelMaster = flag.String("sentinel-master", "mymaster", "name of the master monitored by the sentinel")
+)
+
+func TestParseReplicas(t *testing.T) {
+	replica := func(ip, flags, link string) interface{} {
+		return []interface{}{
+			"name", ip + ":6379",
+			"ip", ip,
+			"port", "6379",
+			"flags", flags,
+			"master-link-status", link,
+		}
+	}
+	reply := []interface{}{
+		replica("10.0.0.1", "slave", "ok"),
+		replica("10.0.0.2", "s_down,slave", "ok"),
+		replica("10.0.0.3", "slave,disconnected", "ok"),
+		replica("10.0.0.4", "slave", "err"),
+		replica("10.0.0.5", "slave", "ok"),
+	}
+
+	addrs, err := parseReplicas(reply)
+	expected := []string{"10.0.0.1:6379", "10.0.0.5:6379"}
+	if err != nil || !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("expected the reachable replicas, received: %v, err: %v", addrs, err)
+	}
+
+	if _, err = parseReplicas("ERR"); err == nil {
+		t.Fatalf("expected an unexpected reply to fail")
+	}
+}
+
+func TestSentinelClient_MasterFallback(t *testing.T) {
+	rc, err := NewRedisClient(*redisURL)
+	if err != nil {
+		t.Fatalf("expected client to be created")
+	}
+	master := rc.(*redisClient)
+	master.client.Set("key", "value", time.Minute)
+
+	// the replica isn't reachable, so the
+	// lookups are sent to the master.
+	sc := &sentinelClient{
+		master: master,
+		replicas: []*redisClient{
+			{redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})},
+		},
+		done: make(chan struct{}),
+	}
+	defer sc.Close()
+
+	val, err := sc.Get("key")
+	if err != nil || val != "value" {
+		t.Fatalf("expected the lookup to fall back to the master, err: %v", err)
+	}
+	val, ttl, err := sc.GetWithTTL("key")
+	if err != nil || val != "value" || ttl <= 0 {
+		t.Fatalf("expected the lookup with ttl to fall back to the master, err: %v", err)
+	}
+}
+
+func TestSentinelClient_Close(t *testing.T) {
+	sc := &sentinelClient{
+		master: &redisClient{redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})},
+		replicas: []*redisClient{
+			{redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})},
+		},
+		done: make(chan struct{}),
+	}
+
+	if sc.Close() != nil || sc.Close() != nil {
+		t.Fatalf("expected close to be safe to call more than once")
+	}
+}
+
+func TestSentinelClient(t *testing.T) {
+	if *sentinelAddr == "" {
+		t.Skip("sentinel-addr isn't set")
+	}
+
+	sc, err := NewSentinelClient(SentinelOptions{
+		MasterName:       *sentinelMaster,
+		SentinelAddrs:    []string{*sentinelAddr},
+		ReadFromReplicas: true,
+	})
+	if err != nil {
+		t.Fatalf("expected client to be created, err: %v", err)
+	}
+	defer sc.(*sentinelClient).Close()
+
+	c := sc.(*sentinelClient)
+	if c.replica() == nil {
+		t.Fatalf("expected the replicas to be discovered")
+	}
+	c.master.client.Set("sentinelKey", "value", 0)
+
+	// wait for the key to be replicated.
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		val, err := sc.Get("sentinelKey")
+		if err == nil && val == "value" {
+			break
+		}
+		if err != nil && err != cache.ErrKeyNotFound || time.Now().After(deadline) {
+			t.Fatalf("expected the key to be read from a replica, err: %v", err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
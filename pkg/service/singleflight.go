@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// call represents an in-flight or completed
+// load for a key.
+type call struct {
+	// done is closed once
+	// the load completes.
+	done chan struct{}
+
+	val string
+	ttl time.Duration
+	err error
+}
+
+// errLoadPanicked is the error of a call
+// whose load panicked.
+var errLoadPanicked = errors.New("service: backend load panicked")
+
+// flightGroup coalesces concurrent loads for the
+// same key, so that only one of them reaches the
+// backing store. This is based on the singleflight
+// package in golang.org/x/sync.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// do runs fn for the key, unless a load for the key is
+// already in flight. In that case it waits for the in-flight
+// load to complete and returns its result.
+// It returns the following:
+//   - the completed call for the key.
+//   - true/false if the call was shared with another caller.
+func (g *flightGroup) do(key string, fn func() (string, time.Duration, error)) (*call, bool) {
+	c, shared, _ := g.doContext(context.Background(), key, fn)
+	return c, shared
+}
+
+// doContext is the counterpart of do, which stops waiting
+// for an in-flight load once the context is done. In that
+// case it returns the error of the context, and the call
+// must not be used.
+func (g *flightGroup) doContext(ctx context.Context, key string, fn func() (string, time.Duration, error)) (*call, bool, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c, true, nil
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	g.run(key, c, fn)
+	return c, false, nil
+}
+
+// doAsync runs fn for the key in the background, unless
+// a load for the key is already in flight. Callers of do
+// for the key wait for the background load. It reports
+// if the load was started.
+func (g *flightGroup) doAsync(key string, fn func() (string, time.Duration, error)) bool {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if _, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		return false
+	}
+
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	go g.run(key, c, fn)
+	return true
+}
+
+// run runs fn for the call, and removes the call once
+// it's done. If fn panics, the waiters are released with
+// errLoadPanicked, and the panic is left to the caller.
+func (g *flightGroup) run(key string, c *call, fn func() (string, time.Duration, error)) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.err = errLoadPanicked
+	c.val, c.ttl, c.err = fn()
+}
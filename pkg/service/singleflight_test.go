@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupCoalescing(t *testing.T) {
+	var g flightGroup
+	var calls, shared int32
+
+	release := make(chan struct{})
+	fn := func() (string, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", time.Second, nil
+	}
+
+	// the first caller starts the load, and is
+	// blocked until the others have joined it.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		g.do("key", fn)
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var joining sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		joining.Add(1)
+		go func() {
+			defer wg.Done()
+			joining.Done()
+			c, ok := g.do("key", fn)
+			if ok {
+				atomic.AddInt32(&shared, 1)
+			}
+			if c.val != "value" || c.ttl != time.Second || c.err != nil {
+				t.Errorf("expected waiters to receive the result of the in-flight load")
+			}
+		}()
+	}
+
+	// let the waiters join the in-flight call.
+	joining.Wait()
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 || shared != 10 {
+		t.Fatalf("expected a single load to be shared by all callers, loads: %d, shared: %d", calls, shared)
+	}
+
+	// the key is released once the load completes.
+	g.do("key", fn)
+	if calls != 2 {
+		t.Fatalf("expected a new load once the in-flight load completed")
+	}
+}
+
+func TestFlightGroupContext(t *testing.T) {
+	var g flightGroup
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go g.do("key", func() (string, time.Duration, error) {
+		close(started)
+		<-release
+		return "value", 0, nil
+	})
+	<-started
+
+	// a waiter stops waiting once its context
+	// is done, leaving the load in flight.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, shared, err := g.doContext(ctx, "key", nil)
+	if c != nil || !shared || err != context.Canceled {
+		t.Fatalf("expected the waiter to fail with the context error, received: %v", err)
+	}
+	close(release)
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		g.do("key", func() (string, time.Duration, error) {
+			close(started)
+			<-release
+			panic("load failed")
+		})
+	}()
+	<-started
+
+	// the waiters are released once the load
+	// panics, and the key is released.
+	waiter := make(chan *call)
+	go func() {
+		c, _ := g.do("key", nil)
+		waiter <- c
+	}()
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+
+	select {
+	case c := <-waiter:
+		if c.err != errLoadPanicked {
+			t.Fatalf("expected the waiter to fail with errLoadPanicked, received: %v", c.err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("expected the waiter to be released once the load panicked")
+	}
+	c, shared := g.do("key", func() (string, time.Duration, error) {
+		return "value", 0, nil
+	})
+	if shared || c.val != "value" {
+		t.Fatalf("expected a new load once the load panicked")
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/vikramsk/rediproxy/pkg/cache"
+)
+
+// Stats reports how well the proxy performs.
+type Stats struct {
+	// Cache reports the stats of the in-memory
+	// cache, if it reports them.
+	Cache cache.Stats `json:"cache"`
+
+	// Backend reports the stats of the
+	// calls made to the backing store.
+	Backend BackendStats `json:"backend"`
+
+	// Breaker reports the state of the circuit
+	// breaker around the backing store, if any.
+	Breaker *BreakerStats `json:"breaker,omitempty"`
+}
+
+// BackendStats reports the stats of the
+// calls made to the backing store.
+type BackendStats struct {
+	// Calls is the number of calls
+	// made to the backing store.
+	Calls uint64 `json:"calls"`
+
+	// Errors is the number of calls which failed
+	// with an error other than cache.ErrKeyNotFound.
+	Errors uint64 `json:"errors"`
+
+	// Coalesced is the number of callers which
+	// shared the result of an in-flight call
+	// instead of calling the backing store.
+	Coalesced uint64 `json:"coalesced"`
+
+	// NegativeHits is the number of lookups for keys
+	// known to be missing in the backing store, which
+	// didn't call the backing store.
+	NegativeHits uint64 `json:"negative_hits"`
+
+	// Stale is the number of lookups served from
+	// the in-memory cache after the ttl of the key,
+	// and Refreshes is the number of background
+	// calls made to refresh those keys.
+	Stale     uint64 `json:"stale"`
+	Refreshes uint64 `json:"refreshes"`
+
+	// StaleOnError is the number of lookups served
+	// from the in-memory cache after the ttl of the
+	// key, because the backing store failed.
+	StaleOnError uint64 `json:"stale_on_error"`
+
+	// Invalidations is the number of keys reported as
+	// modified by the backing store. An invalidation of
+	// every key is counted once.
+	Invalidations uint64 `json:"invalidations"`
+
+	// AvgLatencyMs and MaxLatencyMs report the
+	// latency of the calls in milliseconds.
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	MaxLatencyMs float64 `json:"max_latency_ms"`
+}
+
+// StatsReporter defines the behavior
+// for a service which reports its stats.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// backendCounters tracks the stats of the calls
+// made to the backing store. the fields are
+// accessed atomically, so backendCounters must
+// be the first field of the service to guarantee
+// 64 bit alignment.
+type backendCounters struct {
+	calls     uint64
+	errors    uint64
+	coalesced uint64
+	stale     uint64
+	refreshes uint64
+
+	staleOnError  uint64
+	negativeHits  uint64
+	invalidations uint64
+
+	// latencies are stored in nanoseconds.
+	totalLatency uint64
+	maxLatency   uint64
+}
+
+// record records a call to the backing
+// store, which took the given duration.
+func (bc *backendCounters) record(d time.Duration, err error) {
+	atomic.AddUint64(&bc.calls, 1)
+	if err != nil && err != cache.ErrKeyNotFound {
+		atomic.AddUint64(&bc.errors, 1)
+	}
+
+	ns := uint64(d)
+	atomic.AddUint64(&bc.totalLatency, ns)
+	for {
+		max := atomic.LoadUint64(&bc.maxLatency)
+		if ns <= max || atomic.CompareAndSwapUint64(&bc.maxLatency, max, ns) {
+			break
+		}
+	}
+}
+
+// stats returns a snapshot of the counters.
+func (bc *backendCounters) stats() BackendStats {
+	s := BackendStats{
+		Calls:         atomic.LoadUint64(&bc.calls),
+		Errors:        atomic.LoadUint64(&bc.errors),
+		Coalesced:     atomic.LoadUint64(&bc.coalesced),
+		NegativeHits:  atomic.LoadUint64(&bc.negativeHits),
+		Stale:         atomic.LoadUint64(&bc.stale),
+		Refreshes:     atomic.LoadUint64(&bc.refreshes),
+		StaleOnError:  atomic.LoadUint64(&bc.staleOnError),
+		Invalidations: atomic.LoadUint64(&bc.invalidations),
+		MaxLatencyMs:  float64(atomic.LoadUint64(&bc.maxLatency)) / float64(time.Millisecond),
+	}
+	if s.Calls > 0 {
+		total := float64(atomic.LoadUint64(&bc.totalLatency))
+		s.AvgLatencyMs = total / float64(s.Calls) / float64(time.Millisecond)
+	}
+	return s
+}
@@ -0,0 +1,405 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/vikramsk/rediproxy/pkg/cache"
+)
+
+const (
+	// invalidateChannel is the channel of the
+	// invalidation messages of client tracking.
+	invalidateChannel = "__redis__:invalidate"
+
+	// trackingPingInterval is the time between the pings
+	// on the invalidation connection. The connection is
+	// considered broken if no reply arrives for twice
+	// the interval.
+	trackingPingInterval = time.Second * 15
+
+	// trackingRetryInterval is the time between the
+	// attempts to restore the invalidation connection.
+	trackingRetryInterval = time.Second
+
+	defaultTrackingDialTimeout = time.Second * 5
+)
+
+// InvalidateFunc is the func called with the keys modified
+// in redis. The keys are nil if every key was invalidated,
+// e.g. on FLUSHALL or after a lost invalidation connection.
+type InvalidateFunc func(keys []string)
+
+// InvalidationNotifier defines the behavior for a backing
+// store which reports the keys modified in it.
+type InvalidationNotifier interface {
+	OnInvalidate(fn InvalidateFunc)
+}
+
+// TrackingOptions configures a backend which
+// uses the client side caching of redis 6.
+type TrackingOptions struct {
+	// Broadcast reports every modified key which starts
+	// with one of the prefixes, instead of only the keys
+	// read by the proxy. It's implied by the prefixes.
+	Broadcast bool
+	Prefixes  []string
+
+	// RedisOptions configures the connections
+	// to the redis instance.
+	RedisOptions
+}
+
+// ensure that the tracking client reports
+// the expiry and the invalidation of the keys.
+var _ = cache.TTLGetter(&trackingClient{})
+var _ = cache.ContextGetter(&trackingClient{})
+var _ = cache.ContextTTLGetter(&trackingClient{})
+var _ = InvalidationNotifier(&trackingClient{})
+var _ = io.Closer(&trackingClient{})
+
+// trackingClient is a redis client whose connections
+// are tracked by redis, which sends the invalidation
+// messages for the keys read through them to a separate
+// connection subscribed to the invalidation channel.
+type trackingClient struct {
+	// invalidate holds the InvalidateFunc.
+	invalidate atomic.Value
+
+	// opt is the template for the connections of the
+	// client, and ro the resolved options for the
+	// invalidation connection.
+	opt *redis.Options
+	ro  RedisOptions
+
+	// tracking are the args of CLIENT TRACKING,
+	// without the id of the invalidation connection.
+	tracking []string
+
+	// mu guards the client, which is replaced when the
+	// invalidation connection is restored, and the
+	// invalidation connection.
+	mu     sync.RWMutex
+	client *redisClient
+	conn   net.Conn
+
+	// done is closed once, by Close.
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewTrackingClient initializes a backend, which enables
+// CLIENT TRACKING on its connections to the redis instance.
+// The invalidation messages are reported to the func set with
+// OnInvalidate. The keys cached while the invalidation
+// connection is lost are reported as invalidated once it's
+// restored. It requires redis 6.
+func NewTrackingClient(o TrackingOptions) (cache.Getter, error) {
+	opt, err := o.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	ro, err := o.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &trackingClient{
+		opt:      opt,
+		ro:       ro,
+		tracking: []string{"client", "tracking", "on"},
+		done:     make(chan struct{}),
+	}
+	if o.Broadcast || len(o.Prefixes) > 0 {
+		tc.tracking = append(tc.tracking, "bcast")
+		for _, p := range o.Prefixes {
+			tc.tracking = append(tc.tracking, "prefix", p)
+		}
+	}
+
+	conn, r, id, err := tc.subscribe()
+	if err != nil {
+		return nil, fmt.Errorf("service: could not initialize redis invalidation connection. err: %v", err)
+	}
+	if err := tc.connect(id); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tc.conn = conn
+	go tc.listen(conn, r)
+	return tc, nil
+}
+
+// Get fetches the key from redis.
+func (tc *trackingClient) Get(key string) (string, error) {
+	return tc.current().Get(key)
+}
+
+// GetContext is the context aware counterpart of Get.
+func (tc *trackingClient) GetContext(ctx context.Context, key string) (string, error) {
+	return tc.current().GetContext(ctx, key)
+}
+
+// GetWithTTL fetches the key along
+// with its expiry from redis.
+func (tc *trackingClient) GetWithTTL(key string) (string, time.Duration, error) {
+	return tc.current().GetWithTTL(key)
+}
+
+// GetWithTTLContext is the context aware
+// counterpart of GetWithTTL.
+func (tc *trackingClient) GetWithTTLContext(ctx context.Context, key string) (string, time.Duration, error) {
+	return tc.current().GetWithTTLContext(ctx, key)
+}
+
+// OnInvalidate sets the func called with
+// the keys modified in redis.
+func (tc *trackingClient) OnInvalidate(fn InvalidateFunc) {
+	tc.invalidate.Store(fn)
+}
+
+// Close closes the invalidation connection,
+// and the connections of the client. It is
+// safe to call more than once.
+func (tc *trackingClient) Close() (err error) {
+	tc.closeOnce.Do(func() {
+		close(tc.done)
+
+		tc.mu.Lock()
+		defer tc.mu.Unlock()
+		tc.conn.Close()
+		err = tc.client.Close()
+	})
+	return err
+}
+
+// current returns the current client.
+func (tc *trackingClient) current() *redisClient {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+	return tc.client
+}
+
+// connect replaces the client with one whose connections
+// redirect their invalidation messages to the connection
+// with the id.
+func (tc *trackingClient) connect(id int64) error {
+	args := make([]interface{}, 0, len(tc.tracking)+2)
+	for _, arg := range tc.tracking {
+		args = append(args, arg)
+	}
+	args = append(args, "redirect", strconv.FormatInt(id, 10))
+
+	opt := *tc.opt
+	auth := opt.OnConnect
+	opt.OnConnect = func(cn *redis.Conn) error {
+		if auth != nil {
+			if err := auth(cn); err != nil {
+				return err
+			}
+		}
+		return cn.Process(redis.NewStatusCmd(args...))
+	}
+
+	redisdb := redis.NewClient(&opt)
+	if _, err := redisdb.Ping().Result(); err != nil {
+		redisdb.Close()
+		return fmt.Errorf("service: could not initialize redis tracking client. err: %v", err)
+	}
+
+	tc.mu.Lock()
+	select {
+	case <-tc.done:
+		tc.mu.Unlock()
+		redisdb.Close()
+		return errors.New("service: redis tracking client closed")
+	default:
+	}
+	prev := tc.client
+	tc.client = &redisClient{redisdb}
+	tc.mu.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+	return nil
+}
+
+// subscribe opens the invalidation connection, and
+// returns it along with its reader and its id.
+func (tc *trackingClient) subscribe() (net.Conn, *bufio.Reader, int64, error) {
+	timeout := tc.ro.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultTrackingDialTimeout
+	}
+	addr := tc.opt.Addr
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: timeout}
+	if tc.opt.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tc.opt.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	// the handshake has to complete within the timeout.
+	conn.SetDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	call := func(args ...string) (interface{}, error) {
+		if err := writeCommand(w, args...); err != nil {
+			return nil, err
+		}
+		reply, err := readReply(r)
+		if rerr, ok := reply.(respError); ok {
+			return nil, rerr
+		}
+		return reply, err
+	}
+
+	switch {
+	case tc.ro.Username != "":
+		_, err = call("auth", tc.ro.Username, tc.ro.Password)
+	case tc.ro.Password != "":
+		_, err = call("auth", tc.ro.Password)
+	}
+	var reply interface{}
+	if err == nil {
+		reply, err = call("client", "id")
+	}
+	id, ok := reply.(int64)
+	if err == nil && !ok {
+		err = fmt.Errorf("service: unexpected reply to CLIENT ID: %v", reply)
+	}
+	if err == nil {
+		_, err = call("subscribe", invalidateChannel)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, 0, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, r, id, nil
+}
+
+// listen reports the invalidation messages, until the
+// client is closed. A lost connection is restored, along
+// with the client, and every key is reported as invalidated,
+// as the messages sent in the meantime were lost.
+func (tc *trackingClient) listen(conn net.Conn, r *bufio.Reader) {
+	for {
+		tc.receive(conn, r)
+		conn.Close()
+
+		for {
+			select {
+			case <-tc.done:
+				return
+			case <-time.After(trackingRetryInterval):
+			}
+
+			var id int64
+			var err error
+			conn, r, id, err = tc.subscribe()
+			if err != nil {
+				continue
+			}
+			if err = tc.connect(id); err != nil {
+				conn.Close()
+				continue
+			}
+			break
+		}
+
+		tc.mu.Lock()
+		select {
+		case <-tc.done:
+			tc.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		tc.conn = conn
+		tc.mu.Unlock()
+		tc.notify(nil)
+	}
+}
+
+// receive reads the messages from the invalidation
+// connection, until it fails. The connection is pinged
+// to detect when it's broken.
+func (tc *trackingClient) receive(conn net.Conn, r *bufio.Reader) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		w := bufio.NewWriter(conn)
+		ticker := time.NewTicker(trackingPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if writeCommand(w, "ping") != nil {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(trackingPingInterval * 2))
+		reply, err := readReply(r)
+		if err != nil {
+			return err
+		}
+
+		// the messages are arrays of the kind, the
+		// channel and the payload. the payload is the
+		// array of keys, or nil if every key is invalidated.
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 || msg[0] != "message" || msg[1] != invalidateChannel {
+			continue
+		}
+		if msg[2] == nil {
+			tc.notify(nil)
+			continue
+		}
+		vals, ok := msg[2].([]interface{})
+		if !ok {
+			return errors.New("service: unexpected invalidation message")
+		}
+		keys := make([]string, 0, len(vals))
+		for _, v := range vals {
+			if key, ok := v.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+		tc.notify(keys)
+	}
+}
+
+// notify calls the InvalidateFunc, if it's set.
+func (tc *trackingClient) notify(keys []string) {
+	if fn, ok := tc.invalidate.Load().(InvalidateFunc); ok && fn != nil {
+		fn(keys)
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// trackingServer is a fake redis 6 server,
+// which replies to the commands used by
+// the tracking client.
+type trackingServer struct {
+	l net.Listener
+
+	mu       sync.Mutex
+	ids      int64
+	tracking []string
+
+	// subscribed receives the connections
+	// subscribed to the invalidation channel.
+	subscribed chan net.Conn
+}
+
+func newTrackingServer(t *testing.T) *trackingServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start the fake server, err: %v", err)
+	}
+	ts := &trackingServer{
+		l:          l,
+		ids:        6,
+		subscribed: make(chan net.Conn, 4),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ts.serve(conn)
+		}
+	}()
+	return ts
+}
+
+func (ts *trackingServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		cmd, err := readReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range cmd.([]interface{}) {
+			args = append(args, strings.ToLower(arg.(string)))
+		}
+
+		name := args[0]
+		if len(args) > 1 {
+			name += " " + args[1]
+		}
+
+		ts.mu.Lock()
+		switch name {
+		case "client id":
+			ts.ids++
+			fmt.Fprintf(conn, ":%d\r\n", ts.ids)
+		case "client tracking":
+			ts.tracking = args
+			conn.Write([]byte("+OK\r\n"))
+		case "subscribe " + invalidateChannel:
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(invalidateChannel), invalidateChannel)
+			ts.subscribed <- conn
+		case "ping":
+			conn.Write([]byte("+PONG\r\n"))
+		default:
+			conn.Write([]byte("+OK\r\n"))
+		}
+		ts.mu.Unlock()
+	}
+}
+
+// pushInvalidation pushes an invalidation message for the
+// keys, or for every key if they're nil.
+func pushInvalidation(conn net.Conn, keys []string) {
+	fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n", len(invalidateChannel), invalidateChannel)
+	if keys == nil {
+		conn.Write([]byte("*-1\r\n"))
+		return
+	}
+	fmt.Fprintf(conn, "*%d\r\n", len(keys))
+	for _, key := range keys {
+		fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(key), key)
+	}
+}
+
+func TestTrackingClient(t *testing.T) {
+	ts := newTrackingServer(t)
+	defer ts.l.Close()
+
+	tc, err := NewTrackingClient(TrackingOptions{
+		Prefixes:     []string{"user:"},
+		RedisOptions: RedisOptions{Addr: ts.l.Addr().String()},
+	})
+	if err != nil {
+		t.Fatalf("expected client to be created, err: %v", err)
+	}
+	defer tc.(*trackingClient).Close()
+
+	invalidated := make(chan []string, 1)
+	tc.(InvalidationNotifier).OnInvalidate(func(keys []string) {
+		invalidated <- keys
+	})
+
+	ts.mu.Lock()
+	tracking := ts.tracking
+	ts.mu.Unlock()
+	expected := []string{"client", "tracking", "on", "bcast", "prefix", "user:", "redirect", "7"}
+	if !reflect.DeepEqual(tracking, expected) {
+		t.Fatalf("expected tracking to be redirected to the invalidation connection, received: %v", tracking)
+	}
+
+	conn := <-ts.subscribed
+	pushInvalidation(conn, []string{"user:1", "user:2"})
+	if keys := <-invalidated; !reflect.DeepEqual(keys, []string{"user:1", "user:2"}) {
+		t.Fatalf("expected the invalidated keys to be reported, received: %v", keys)
+	}
+	pushInvalidation(conn, nil)
+	if keys := <-invalidated; keys != nil {
+		t.Fatalf("expected a flush to invalidate every key, received: %v", keys)
+	}
+
+	// once the invalidation connection is restored, the
+	// tracking is redirected to it, and every key is
+	// invalidated as the messages in between were lost.
+	conn.Close()
+	select {
+	case keys := <-invalidated:
+		if keys != nil {
+			t.Fatalf("expected a restored connection to invalidate every key, received: %v", keys)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("expected the invalidation connection to be restored")
+	}
+	ts.mu.Lock()
+	tracking = ts.tracking
+	ts.mu.Unlock()
+	if tracking[len(tracking)-1] != "8" {
+		t.Fatalf("expected tracking to be redirected to the restored connection, received: %v", tracking)
+	}
+
+	if tc.(*trackingClient).Close() != nil || tc.(*trackingClient).Close() != nil {
+		t.Fatalf("expected close to be safe to call more than once")
+	}
+}
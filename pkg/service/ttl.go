@@ -0,0 +1,23 @@
+package service
+
+import (
+	"strings"
+	"time"
+)
+
+// PrefixTTL returns a func which resolves the time to
+// live for a key using the longest matching prefix in
+// rules. Keys that don't match any prefix resolve to 0,
+// which falls back to the default ttl of the cache.
+func PrefixTTL(rules map[string]time.Duration) func(key string) time.Duration {
+	return func(key string) time.Duration {
+		var match string
+		var ttl time.Duration
+		for prefix, d := range rules {
+			if strings.HasPrefix(key, prefix) && len(prefix) >= len(match) {
+				match, ttl = prefix, d
+			}
+		}
+		return ttl
+	}
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrefixTTL(t *testing.T) {
+	ttl := PrefixTTL(map[string]time.Duration{
+		"session:":       time.Minute,
+		"session:admin:": time.Second,
+		"config:":        time.Hour * 24,
+	})
+
+	scenarios := map[string]time.Duration{
+		"session:123":       time.Minute,
+		"session:admin:123": time.Second,
+		"config:flags":      time.Hour * 24,
+		"other":             0,
+	}
+
+	for key, expected := range scenarios {
+		if got := ttl(key); got != expected {
+			t.Errorf("unexpected ttl for key: %s, expected: %s, received: %s", key, expected, got)
+		}
+	}
+}
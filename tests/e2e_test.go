@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -90,6 +91,90 @@ func TestE2EFlow(t *testing.T) {
 	for r := range responseChan {
 		assertResponse(t, keyLimit, r)
 	}
+	s := getStats(t)
+	t.Logf("backend calls: %d coalesced: %d", s.Calls, s.Coalesced)
+}
+
+// busyScript keeps redis busy for ARGV[1] microseconds,
+// which holds the loads of rediproxy in flight.
+const busyScript = `
+local t = redis.call('TIME')
+local deadline = t[1] * 1000000 + t[2] + ARGV[1]
+repeat t = redis.call('TIME') until t[1] * 1000000 + t[2] >= deadline
+return 1`
+
+func TestE2ECoalescing(t *testing.T) {
+	c, err := createRedisClient(*redisURL)
+	if err != nil {
+		t.Fatalf("could not create client, err: %v", err)
+	}
+	k := key(-1)
+	c.Set(k, value(-1), 0)
+	defer c.Del(k)
+
+	// the load for the first request waits for redis,
+	// while the other requests join it.
+	before := getStats(t)
+	busy := make(chan error, 1)
+	go func() {
+		busy <- c.Eval(busyScript, nil, 500000).Err()
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(serviceURL + k)
+			if err != nil {
+				t.Errorf("unexpected error while connecting to rediproxy. err: %v", err)
+				return
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != value(-1) {
+				t.Errorf("expected every request to receive the value")
+			}
+		}()
+	}
+	wg.Wait()
+	if err := <-busy; err != nil {
+		t.Fatalf("could not keep redis busy, err: %v", err)
+	}
+
+	after := getStats(t)
+	if calls := after.Calls - before.Calls; calls != 1 {
+		t.Errorf("expected the concurrent misses to make a single backend call, received: %d", calls)
+	}
+	if after.Coalesced == before.Coalesced {
+		t.Errorf("expected the concurrent misses to be coalesced into the backend call")
+	}
+}
+
+// backendStats are the stats of the
+// calls made to the backing store.
+type backendStats struct {
+	Calls     uint64 `json:"calls"`
+	Coalesced uint64 `json:"coalesced"`
+}
+
+// getStats returns the number of backend calls and the
+// number of requests which were coalesced into them.
+func getStats(t *testing.T) backendStats {
+	resp, err := http.Get(fmt.Sprintf("http://%s/stats", *proxyURL))
+	if err != nil {
+		t.Fatalf("unexpected error while connecting to rediproxy. err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var stats struct {
+		Backend backendStats `json:"backend"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("could not decode stats, err: %v", err)
+	}
+	return stats.Backend
 }
 
 // assertResponse performs the assertions on the response values for a test run.